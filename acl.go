@@ -0,0 +1,134 @@
+package nerv
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+const (
+	// Matches exactly one dot-separated segment of a topic name
+	TopicWildcardSegment = "*"
+
+	// As the last segment of a pattern, matches every remaining segment
+	TopicWildcardRest = "**"
+)
+
+var ErrEngineUnknownProducer = errors.New("unknown producer")
+var ErrEnginePublishDenied = errors.New("producer may not publish to topic")
+
+// Identity that events are submitted under, along with the topics
+// it may publish to. Topics are given as patterns that may contain
+// wildcards, such that "orders.*" permits "orders.new" but not
+// "orders.eu.new", which "orders.**" permits as well
+type ProducerCfg struct {
+	Id      string
+	Publish []string
+}
+
+func NewProducer(id string) *ProducerCfg {
+	return &ProducerCfg{
+		Id:      id,
+		Publish: make([]string, 0),
+	}
+}
+
+func (p *ProducerCfg) PermitTopics(patterns ...string) *ProducerCfg {
+	p.Publish = append(p.Publish, patterns...)
+	return p
+}
+
+type producerAcl struct {
+	producers map[string][]string
+	permitAll bool
+	mu        sync.RWMutex
+}
+
+func newProducerAcl() *producerAcl {
+	return &producerAcl{
+		producers: make(map[string][]string),
+		permitAll: true,
+	}
+}
+
+// Decide whether unregistered producers may publish to any topic, as they
+// may by default. Registered producers are always held to their patterns
+func (eng *Engine) WithUnknownProducers(permit bool) *Engine {
+	eng.acl.mu.Lock()
+	defer eng.acl.mu.Unlock()
+
+	eng.acl.permitAll = permit
+	return eng
+}
+
+// Register a producer, restricting the topics it may publish to. Registering
+// a producer again replaces the topics it was permitted before
+func (eng *Engine) RegisterProducer(cfg *ProducerCfg) error {
+
+	if len(cfg.Id) == 0 {
+		return ErrEngineUnknownProducer
+	}
+
+	eng.log().Debug("RegisterProducer", "producer", cfg.Id, "publish", cfg.Publish)
+
+	eng.acl.mu.Lock()
+	defer eng.acl.mu.Unlock()
+
+	eng.acl.producers[cfg.Id] = append([]string{}, cfg.Publish...)
+	return nil
+}
+
+func (eng *Engine) DeregisterProducer(id string) error {
+
+	eng.acl.mu.Lock()
+	defer eng.acl.mu.Unlock()
+
+	if _, ok := eng.acl.producers[id]; !ok {
+		return ErrEngineUnknownProducer
+	}
+
+	delete(eng.acl.producers, id)
+	return nil
+}
+
+// Check that a producer may publish to a topic
+func (eng *Engine) Permitted(producer string, topic string) error {
+
+	eng.acl.mu.RLock()
+	defer eng.acl.mu.RUnlock()
+
+	patterns, ok := eng.acl.producers[producer]
+	if !ok {
+		if eng.acl.permitAll {
+			return nil
+		}
+		return ErrEngineUnknownProducer
+	}
+
+	for _, pattern := range patterns {
+		if MatchTopic(pattern, topic) {
+			return nil
+		}
+	}
+	return ErrEnginePublishDenied
+}
+
+// Check whether a topic name matches a pattern that may contain wildcards
+func MatchTopic(pattern string, topic string) bool {
+
+	patternSegments := strings.Split(pattern, ".")
+	topicSegments := strings.Split(topic, ".")
+
+	for i, segment := range patternSegments {
+		if segment == TopicWildcardRest && i == len(patternSegments)-1 {
+			return len(topicSegments) > i
+		}
+		if i >= len(topicSegments) {
+			return false
+		}
+		if segment != TopicWildcardSegment && segment != topicSegments[i] {
+			return false
+		}
+	}
+	return len(topicSegments) == len(patternSegments)
+}
@@ -0,0 +1,195 @@
+package nerv
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMatchTopic(t *testing.T) {
+
+	for _, tc := range []struct {
+		pattern string
+		topic   string
+		matches bool
+	}{
+		{"orders", "orders", true},
+		{"orders", "orders.new", false},
+		{"orders.*", "orders.new", true},
+		{"orders.*", "orders", false},
+		{"orders.*", "orders.eu.new", false},
+		{"orders.*.new", "orders.eu.new", true},
+		{"orders.**", "orders.eu.new", true},
+		{"orders.**", "orders", false},
+		{"**", "anything.at.all", true},
+		{"orders.**.new", "orders.eu.new", false},
+	} {
+		if MatchTopic(tc.pattern, tc.topic) != tc.matches {
+			t.Fatalf("expected match of %s against %s to be %v", tc.pattern, tc.topic, tc.matches)
+		}
+	}
+}
+
+func TestProducerAcl(t *testing.T) {
+
+	engine := NewEngine()
+
+	for _, topic := range []string{"orders.new", "orders.audit", "billing"} {
+		if err := engine.CreateTopic(NewTopic(topic)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	if err := engine.RegisterProducer(NewProducer("shop").PermitTopics("orders.*")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Submit("shop", "orders.new", 1); err != nil {
+		t.Fatalf("expected shop to publish to orders, got %v", err)
+	}
+
+	if err := engine.Submit("shop", "billing", 1); err != ErrEnginePublishDenied {
+		t.Fatalf("expected shop to be denied billing, got %v", err)
+	}
+
+	// Refused before anything is done on the event's behalf,
+	// such as dead lettering it for being over the hop limit
+	looping := Event{Spawned: time.Now(), Topic: "billing", Producer: "shop", Hops: defaultMaxHops + 1}
+	if err := engine.SubmitEvent(looping); err != ErrEnginePublishDenied {
+		t.Fatalf("expected shop to be denied billing before the hop limit, got %v", err)
+	}
+
+	if err := engine.Submit("stranger", "billing", 1); err != nil {
+		t.Fatalf("expected unknown producers to be permitted by default, got %v", err)
+	}
+
+	engine.WithUnknownProducers(false)
+
+	if err := engine.Submit("stranger", "billing", 1); err != ErrEngineUnknownProducer {
+		t.Fatalf("expected unknown producer to be refused, got %v", err)
+	}
+
+	if err := engine.DeregisterProducer("shop"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Submit("shop", "orders.new", 1); err != ErrEngineUnknownProducer {
+		t.Fatalf("expected deregistered producer to be refused, got %v", err)
+	}
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestProducerAclConfig(t *testing.T) {
+
+	cfg, err := ParseConfig([]byte(`{
+		"topics": [{"name": "orders"}, {"name": "orders.audit"}],
+		"forwarding": [{"from": "orders", "to": "orders.audit"}],
+		"producers": [{"id": "shop", "publish": ["orders"]}],
+		"restrict_producers": true
+	}`))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	engine, err := NewEngineFromConfig(cfg, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	audited := make(chan *Event, 1)
+	engine.Register(Consumer{
+		Id: "auditor",
+		Fn: func(event *Event) {
+			audited <- event
+		},
+	})
+	engine.SubscribeTo("orders.audit", "auditor")
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer engine.Stop()
+
+	if err := engine.Submit("stranger", "orders", 1); err != ErrEngineUnknownProducer {
+		t.Fatalf("expected unlisted producer to be refused, got %v", err)
+	}
+
+	if err := engine.Submit("shop", "orders.audit", 1); err != ErrEnginePublishDenied {
+		t.Fatalf("expected shop to be denied the audit topic, got %v", err)
+	}
+
+	// Forwarding is declared by the configuration and so isn't
+	// held to the permissions of the producer it forwards for
+	if err := engine.Submit("shop", "orders", 1); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if event := <-audited; event.Producer != "shop" {
+		t.Fatalf("expected forwarded event from shop, got %s", event.Producer)
+	}
+}
+
+func TestDeclaredRoutesWithRestrictedProducers(t *testing.T) {
+
+	engine := NewEngine().WithUnknownProducers(false)
+
+	for _, topic := range []string{"in", "orders", "payments"} {
+		if err := engine.CreateTopic(NewTopic(topic)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	if err := engine.RegisterProducer(NewProducer("shop").PermitTopics("in", "orders", "payments")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Streams and joins aren't producers that could be registered
+	if err := From(engine, "in").Map(func(event *Event) interface{} {
+		return event.Data.(int) * 2
+	}).To("out"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := Join(engine, JoinCfg{
+		Topics: []string{"orders", "payments"},
+		Key:    func(event *Event) string { return event.Id },
+		Window: time.Second,
+		Output: "orders.paid",
+	}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	recvd := make(chan *Event, 2)
+	engine.Register(Consumer{
+		Id: "reader",
+		Fn: func(event *Event) {
+			recvd <- event
+		},
+	})
+	engine.SubscribeTo("out", "reader")
+	engine.SubscribeTo("orders.paid", "reader")
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	engine.Submit("shop", "in", 21)
+	engine.SubmitEvent(Event{Spawned: time.Now(), Topic: "orders", Producer: "shop", Id: "order-1"})
+	engine.SubmitEvent(Event{Spawned: time.Now(), Topic: "payments", Producer: "shop", Id: "order-1"})
+
+	time.Sleep(50 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if len(recvd) != 2 {
+		t.Fatalf("expected stream and join output to be delivered, got %d events", len(recvd))
+	}
+}
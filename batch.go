@@ -0,0 +1,132 @@
+package nerv
+
+import (
+	"sync"
+	"time"
+)
+
+// Something that receives nerv events in batches
+type BatchRecvr func(events []*Event)
+
+// A consumer that is handed events in batches. A batch is delivered once
+// MaxSize events have accumulated or MaxWait has elapsed since the first
+// event of the batch arrived, whichever comes first. Any partial batch
+// is delivered when the engine stops
+type BatchConsumer struct {
+	Id      string
+	Fn      BatchRecvr
+	MaxSize int
+	MaxWait time.Duration
+}
+
+// Accumulates events for a batch consumer. The lock is held while the
+// batch is handed off so batches are always delivered in order
+type batcher struct {
+	eng     *Engine
+	cfg     BatchConsumer
+	pending []*Event
+	timer   *time.Timer
+	mu      sync.Mutex
+
+	// Incremented with every batch handed off, so that a timer
+	// which fired for an earlier batch leaves the next one be
+	generation uint64
+}
+
+func newBatcher(eng *Engine, cfg BatchConsumer) *batcher {
+	if cfg.MaxSize < 1 {
+		cfg.MaxSize = 1
+	}
+	return &batcher{
+		eng:     eng,
+		cfg:     cfg,
+		pending: make([]*Event, 0, cfg.MaxSize),
+	}
+}
+
+func (b *batcher) add(event *Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.pending = append(b.pending, event)
+
+	if len(b.pending) >= b.cfg.MaxSize {
+		b.deliver()
+		return
+	}
+
+	if len(b.pending) == 1 && b.cfg.MaxWait > 0 {
+		generation := b.generation
+		b.timer = time.AfterFunc(b.cfg.MaxWait, func() {
+			b.expire(generation)
+		})
+	}
+}
+
+// Hand off the batch the timer was started for, unless
+// it was already handed off for having filled up
+func (b *batcher) expire(generation uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if generation != b.generation {
+		return
+	}
+	b.deliver()
+}
+
+func (b *batcher) flush() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.deliver()
+}
+
+// Hand off the pending batch. Expects b.mu to be held
+func (b *batcher) deliver() {
+	b.generation += 1
+
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+
+	if len(b.pending) == 0 {
+		return
+	}
+
+	batch := b.pending
+	b.pending = make([]*Event, 0, b.cfg.MaxSize)
+
+	b.eng.log().Debug("deliver batch", "consumer", b.cfg.Id, "size", len(batch))
+	b.cfg.Fn(batch)
+}
+
+// Register a consumer that receives events in batches. Once registered it
+// is subscribed to topics, and deregistered, like any other consumer
+func (eng *Engine) RegisterBatch(bc BatchConsumer) {
+
+	b := newBatcher(eng, bc)
+
+	eng.subMu.Lock()
+	eng.batchers[bc.Id] = b
+	eng.subMu.Unlock()
+
+	eng.Register(Consumer{
+		Id: bc.Id,
+		Fn: b.add,
+	})
+}
+
+func (eng *Engine) flushBatches() {
+	eng.subMu.Lock()
+	batchers := make([]*batcher, 0, len(eng.batchers))
+	for _, b := range eng.batchers {
+		batchers = append(batchers, b)
+	}
+	eng.subMu.Unlock()
+
+	for _, b := range batchers {
+		b.flush()
+	}
+}
@@ -0,0 +1,259 @@
+package nerv
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	BreakerClosed = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+// Configuration of a circuit breaker placed around a consumer. Deliveries
+// that panic or exceed the latency budget are failures. Once enough consecutive
+// failures occur the circuit opens and the consumer is skipped until OpenDuration
+// has elapsed, after which trial deliveries decide if the circuit closes again
+type BreakerCfg struct {
+	FailureThreshold int
+	LatencyBudget    time.Duration
+	OpenDuration     time.Duration
+	HalfOpenTrials   int
+}
+
+// Data of the event published on nerv.internal when
+// the circuit around a consumer changes state
+type BreakerStateChange struct {
+	Consumer string
+	From     int
+	To       int
+}
+
+// Data of the event published on nerv.deadletter for events
+// that could not be delivered to a consumer
+type DeadLetter struct {
+	Consumer string
+	Reason   string
+	Event    *Event
+}
+
+type consumerBreaker struct {
+	cfg       BreakerCfg
+	state     int
+	failures  int
+	successes int
+	trials    int
+	openedAt  time.Time
+	mu        sync.Mutex
+}
+
+func newConsumerBreaker(cfg BreakerCfg) *consumerBreaker {
+	if cfg.FailureThreshold < 1 {
+		cfg.FailureThreshold = 1
+	}
+	if cfg.HalfOpenTrials < 1 {
+		cfg.HalfOpenTrials = 1
+	}
+	return &consumerBreaker{
+		cfg:   cfg,
+		state: BreakerClosed,
+	}
+}
+
+func (b *consumerBreaker) transition(to int) *BreakerStateChange {
+	change := &BreakerStateChange{
+		From: b.state,
+		To:   to,
+	}
+	b.state = to
+	b.failures = 0
+	b.successes = 0
+	b.trials = 0
+	if to == BreakerOpen {
+		b.openedAt = time.Now()
+	}
+	return change
+}
+
+// Indicate if the consumer may be handed an event. An open circuit
+// moves to half-open once it has been open long enough, after which
+// only HalfOpenTrials deliveries are let through until their outcome
+// decides whether the circuit closes or opens again
+func (b *consumerBreaker) allow() (bool, *BreakerStateChange) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerClosed:
+		return true, nil
+	case BreakerHalfOpen:
+		if b.trials >= b.cfg.HalfOpenTrials {
+			return false, nil
+		}
+		b.trials += 1
+		return true, nil
+	}
+
+	if time.Since(b.openedAt) < b.cfg.OpenDuration {
+		return false, nil
+	}
+
+	change := b.transition(BreakerHalfOpen)
+	b.trials = 1
+	return true, change
+}
+
+// Record the outcome of a delivery, returning the
+// state change that it caused, if any
+func (b *consumerBreaker) record(success bool) *BreakerStateChange {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerClosed:
+		if success {
+			b.failures = 0
+			return nil
+		}
+		b.failures += 1
+		if b.failures >= b.cfg.FailureThreshold {
+			return b.transition(BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		if !success {
+			return b.transition(BreakerOpen)
+		}
+		b.successes += 1
+		if b.successes >= b.cfg.HalfOpenTrials {
+			return b.transition(BreakerClosed)
+		}
+	}
+	return nil
+}
+
+func (b *consumerBreaker) current() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Invoke the consumer, reporting a panic as a failure
+// rather than bringing down the engine
+func invokeRecovered(fn EventRecvr, event *Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("consumer panic: %v", r)
+		}
+	}()
+	fn(event)
+	return nil
+}
+
+// Place a circuit breaker around every consumer that does not have
+// its own breaker set with SetConsumerBreaker
+func (eng *Engine) WithCircuitBreakers(cfg BreakerCfg) *Engine {
+	eng.breakerMu.Lock()
+	defer eng.breakerMu.Unlock()
+
+	eng.defaultBreaker = &cfg
+	return eng
+}
+
+// Place a circuit breaker around a specific consumer
+func (eng *Engine) SetConsumerBreaker(consumerId string, cfg BreakerCfg) {
+	eng.breakerMu.Lock()
+	defer eng.breakerMu.Unlock()
+
+	eng.breakers[consumerId] = newConsumerBreaker(cfg)
+}
+
+// Retrieve the state of the circuit around a consumer. Consumers
+// without a breaker are always considered closed
+func (eng *Engine) ConsumerBreakerState(consumerId string) int {
+	breaker := eng.breakerFor(consumerId)
+	if breaker == nil {
+		return BreakerClosed
+	}
+	return breaker.current()
+}
+
+func (eng *Engine) breakerFor(consumerId string) *consumerBreaker {
+	eng.breakerMu.Lock()
+	defer eng.breakerMu.Unlock()
+
+	breaker, ok := eng.breakers[consumerId]
+	if ok {
+		return breaker
+	}
+
+	if eng.defaultBreaker == nil {
+		return nil
+	}
+
+	breaker = newConsumerBreaker(*eng.defaultBreaker)
+	eng.breakers[consumerId] = breaker
+	return breaker
+}
+
+// Check if the consumer's circuit permits delivery, announcing
+// the move to half-open if that is what allowed it
+func (eng *Engine) available(sub *subscriber) bool {
+	breaker := eng.breakerFor(sub.id)
+	if breaker == nil {
+		return true
+	}
+	ok, change := breaker.allow()
+	eng.announceBreaker(sub.id, change)
+	return ok
+}
+
+// Hand an event to a subscriber, recording the outcome
+// with its circuit breaker if it has one
+func (eng *Engine) deliver(sub *subscriber, event *Event) {
+
+	breaker := eng.breakerFor(sub.id)
+	if breaker == nil {
+		sub.fn(event)
+		return
+	}
+
+	start := time.Now()
+	err := invokeRecovered(sub.fn, event)
+	if err != nil {
+		eng.log().Warn("consumer failed", "consumer", sub.id, "topic", event.Topic, "err", err.Error())
+	} else if budget := breaker.cfg.LatencyBudget; budget > 0 && time.Since(start) > budget {
+		err = fmt.Errorf("latency budget of %v exceeded", budget)
+		eng.log().Warn("consumer too slow", "consumer", sub.id, "topic", event.Topic)
+	}
+
+	eng.announceBreaker(sub.id, breaker.record(err == nil))
+}
+
+func (eng *Engine) announceBreaker(consumerId string, change *BreakerStateChange) {
+	if change == nil {
+		return
+	}
+	change.Consumer = consumerId
+	eng.log().Info("consumer circuit changed", "consumer", consumerId, "from", change.From, "to", change.To)
+	eng.publishInternal(TopicInternal, change)
+}
+
+func (eng *Engine) deadLetter(sub *subscriber, event *Event, reason string) {
+	if event.Topic == TopicDeadLetter {
+		eng.log().Warn("discarding undeliverable dead letter", "reason", reason)
+		return
+	}
+	id := ""
+	if sub != nil {
+		id = sub.id
+	}
+	eng.publishInternal(TopicDeadLetter, &DeadLetter{
+		Consumer: id,
+		Reason:   reason,
+		Event:    event,
+	})
+}
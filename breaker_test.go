@@ -0,0 +1,224 @@
+package nerv
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerDirect(t *testing.T) {
+
+	topicName := "workers"
+
+	engine := NewEngine().
+		WithCircuitBreakers(BreakerCfg{
+			FailureThreshold: 2,
+			OpenDuration:     300 * time.Millisecond,
+			HalfOpenTrials:   1,
+		})
+
+	if err := engine.CreateTopic(
+		NewTopic(topicName).
+			UsingDirect().
+			UsingRoundRobinSelection()); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	var goodRecvd atomic.Int32
+	var badRecvd atomic.Int32
+	var badHealthy atomic.Bool
+
+	engine.Register(Consumer{
+		Id: "good",
+		Fn: func(event *Event) {
+			goodRecvd.Add(1)
+		},
+	})
+
+	engine.Register(Consumer{
+		Id: "bad",
+		Fn: func(event *Event) {
+			badRecvd.Add(1)
+			if !badHealthy.Load() {
+				panic("consumer is broken")
+			}
+		},
+	})
+
+	changesMu := new(sync.Mutex)
+	changes := make([]BreakerStateChange, 0)
+
+	engine.Register(Consumer{
+		Id: "watcher",
+		Fn: func(event *Event) {
+			if change, ok := event.Data.(*BreakerStateChange); ok {
+				changesMu.Lock()
+				defer changesMu.Unlock()
+				changes = append(changes, *change)
+			}
+		},
+	})
+
+	if err := engine.SubscribeTo(topicName, "good", "bad"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.SubscribeTo(TopicInternal, "watcher"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// good, bad, good, bad (opens), then only good
+	for i := 0; i < 8; i++ {
+		engine.Submit("producer", topicName, i)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if state := engine.ConsumerBreakerState("bad"); state != BreakerOpen {
+		t.Fatalf("expected circuit around bad consumer to be open, got %d", state)
+	}
+
+	if goodRecvd.Load() != 6 || badRecvd.Load() != 2 {
+		t.Fatalf("expected 6/2 split after circuit opened, got %d/%d", goodRecvd.Load(), badRecvd.Load())
+	}
+
+	badHealthy.Store(true)
+
+	time.Sleep(400 * time.Millisecond)
+
+	for i := 0; i < 4; i++ {
+		engine.Submit("producer", topicName, i)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if state := engine.ConsumerBreakerState("bad"); state != BreakerClosed {
+		t.Fatalf("expected circuit around bad consumer to close after trial, got %d", state)
+	}
+
+	if badRecvd.Load() < 3 {
+		t.Fatal("bad consumer was not probed after recovering")
+	}
+
+	changesMu.Lock()
+	defer changesMu.Unlock()
+
+	expected := []int{BreakerOpen, BreakerHalfOpen, BreakerClosed}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %d state changes on %s, got %d", len(expected), TopicInternal, len(changes))
+	}
+	for i, change := range changes {
+		if change.Consumer != "bad" || change.To != expected[i] {
+			t.Fatalf("unexpected state change %+v", change)
+		}
+	}
+}
+
+func TestCircuitBreakerDeadLetter(t *testing.T) {
+
+	topicName := "broadcasted"
+
+	engine := NewEngine()
+
+	if err := engine.CreateTopic(NewTopic(topicName)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	engine.SetConsumerBreaker("slow", BreakerCfg{
+		FailureThreshold: 1,
+		LatencyBudget:    10 * time.Millisecond,
+		OpenDuration:     time.Minute,
+	})
+
+	engine.Register(Consumer{
+		Id: "slow",
+		Fn: func(event *Event) {
+			time.Sleep(50 * time.Millisecond)
+		},
+	})
+
+	var lettersRecvd atomic.Int32
+
+	engine.Register(Consumer{
+		Id: "letters",
+		Fn: func(event *Event) {
+			letter := event.Data.(*DeadLetter)
+			if letter.Consumer != "slow" || letter.Event.Topic != topicName {
+				t.Errorf("unexpected dead letter %+v", letter)
+			}
+			lettersRecvd.Add(1)
+		},
+	})
+
+	if err := engine.SubscribeTo(topicName, "slow"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.SubscribeTo(TopicDeadLetter, "letters"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		engine.Submit("producer", topicName, i)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if lettersRecvd.Load() != 2 {
+		t.Fatalf("expected 2 dead letters, got %d", lettersRecvd.Load())
+	}
+}
+
+func TestCircuitBreakerHalfOpenTrials(t *testing.T) {
+
+	breaker := newConsumerBreaker(BreakerCfg{
+		FailureThreshold: 1,
+		OpenDuration:     10 * time.Millisecond,
+		HalfOpenTrials:   2,
+	})
+
+	breaker.record(false)
+
+	if ok, _ := breaker.allow(); ok {
+		t.Fatal("expected open circuit to refuse delivery")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	// Only as many deliveries as there are trials are let
+	// through before their outcome is known
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		if ok, _ := breaker.allow(); ok {
+			allowed++
+		}
+	}
+
+	if allowed != 2 || breaker.current() != BreakerHalfOpen {
+		t.Fatalf("expected 2 trials while half-open, got %d", allowed)
+	}
+
+	breaker.record(true)
+	breaker.record(true)
+
+	if breaker.current() != BreakerClosed {
+		t.Fatalf("expected circuit to close after its trials, got %d", breaker.current())
+	}
+}
@@ -0,0 +1,257 @@
+package nerv
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+var ErrConfigInvalid = errors.New("invalid engine configuration")
+var ErrConfigUnknownModuleKind = errors.New("unknown module kind")
+
+// Creates a module from the settings given to it in a configuration
+type ModuleFactory func(settings json.RawMessage) (Module, error)
+
+// Declarative description of an engine, its topology and its modules.
+// With RestrictProducers set only the listed producers may publish
+type Config struct {
+	Topics     []TopicSpec   `json:"topics"`
+	Forwarding []ForwardSpec `json:"forwarding"`
+	Modules    []ModuleSpec  `json:"modules"`
+
+	// Names that events may be submitted to in place of a topic
+	Aliases map[string]string `json:"aliases,omitempty"`
+
+	Producers         []ProducerSpec `json:"producers"`
+	RestrictProducers bool           `json:"restrict_producers,omitempty"`
+
+	// Times an event may be resubmitted before it's dropped as
+	// looping. Zero keeps the engine's default
+	MaxHops int `json:"max_hops,omitempty"`
+}
+
+// Description of a topic. Distribution is "broadcast" (default) or "direct",
+// and Selection is "arbitrary" (default), "round-robin" or "random"
+type TopicSpec struct {
+	Name         string         `json:"name"`
+	Distribution string         `json:"distribution,omitempty"`
+	Selection    string         `json:"selection,omitempty"`
+	LastValues   int            `json:"last_values,omitempty"`
+	DedupWindow  Duration       `json:"dedup_window,omitempty"`
+	Retention    *RetentionSpec `json:"retention,omitempty"`
+}
+
+// Description of a topic's log. Zero means unbounded
+type RetentionSpec struct {
+	MaxAge    Duration `json:"max_age,omitempty"`
+	MaxEvents int      `json:"max_events,omitempty"`
+}
+
+// Every event submitted to From, or any of Sources, is submitted to To as
+// well, keeping its producer and id. From and Sources may hold wildcards,
+// and Producer restricts forwarding to the producers matching it
+type ForwardSpec struct {
+	Id       string   `json:"id,omitempty"`
+	From     string   `json:"from,omitempty"`
+	Sources  []string `json:"sources,omitempty"`
+	To       string   `json:"to"`
+	Producer string   `json:"producer,omitempty"`
+}
+
+// A producer and the topic patterns it may publish to
+type ProducerSpec struct {
+	Id      string   `json:"id"`
+	Publish []string `json:"publish"`
+}
+
+// Description of a module created by the factory registered for Kind.
+// Settings are handed to the factory as they are, and Topics are
+// created for the module when it's handed to the engine
+type ModuleSpec struct {
+	Kind     string          `json:"kind"`
+	Settings json.RawMessage `json:"settings,omitempty"`
+	Topics   []TopicSpec     `json:"topics,omitempty"`
+}
+
+// A time.Duration that is written in configurations
+// as a string such as "1m30s"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(encoded)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Load a configuration from a json file
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data)
+}
+
+// Parse a json configuration. Fields that aren't part of the
+// configuration are refused rather than silently ignored
+func ParseConfig(data []byte) (*Config, error) {
+	cfg := &Config{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrConfigInvalid, err)
+	}
+	return cfg, nil
+}
+
+// Build the topic configuration a spec describes
+func (spec TopicSpec) TopicCfg() (*TopicCfg, error) {
+
+	if len(spec.Name) == 0 {
+		return nil, fmt.Errorf("%w: topic without a name", ErrConfigInvalid)
+	}
+
+	topic := NewTopic(spec.Name)
+
+	switch spec.Distribution {
+	case "", "broadcast":
+		topic.UsingBroadcast()
+	case "direct":
+		topic.UsingDirect()
+	default:
+		return nil, fmt.Errorf("%w: topic %s has unknown distribution %s", ErrConfigInvalid, spec.Name, spec.Distribution)
+	}
+
+	switch spec.Selection {
+	case "", "arbitrary":
+		topic.UsingArbitrary()
+	case "round-robin":
+		topic.UsingRoundRobinSelection()
+	case "random":
+		topic.UsingRandomSelection()
+	default:
+		return nil, fmt.Errorf("%w: topic %s has unknown selection %s", ErrConfigInvalid, spec.Name, spec.Selection)
+	}
+
+	if spec.LastValues > 0 {
+		topic.UsingLastValueCache(spec.LastValues)
+	}
+
+	if spec.DedupWindow > 0 {
+		topic.UsingDeduplication(time.Duration(spec.DedupWindow))
+	}
+
+	if spec.Retention != nil {
+		topic.UsingRetention(time.Duration(spec.Retention.MaxAge), spec.Retention.MaxEvents)
+	}
+
+	return topic, nil
+}
+
+// Create an engine with the topics, forwarding and modules that the
+// configuration describes. Modules are created by the factory given for
+// their kind, or the one registered with RegisterModuleFactory if none
+// is given, and are handed to the engine in the order listed
+func NewEngineFromConfig(cfg *Config, overrides map[string]ModuleFactory) (*Engine, error) {
+
+	eng := NewEngine()
+
+	for _, spec := range cfg.Topics {
+		topic, err := spec.TopicCfg()
+		if err != nil {
+			return nil, err
+		}
+		if err := eng.CreateTopic(topic); err != nil {
+			return nil, fmt.Errorf("topic %s: %w", spec.Name, err)
+		}
+	}
+
+	for _, spec := range cfg.Producers {
+		if err := eng.RegisterProducer(NewProducer(spec.Id).PermitTopics(spec.Publish...)); err != nil {
+			return nil, fmt.Errorf("%w: producer without an id", ErrConfigInvalid)
+		}
+	}
+
+	eng.WithUnknownProducers(!cfg.RestrictProducers)
+
+	if cfg.MaxHops > 0 {
+		eng.WithMaxHops(cfg.MaxHops)
+	}
+
+	// Applied in a fixed order so that conflicting aliases
+	// are reported the same way every time
+	aliases := make([]string, 0, len(cfg.Aliases))
+	for alias := range cfg.Aliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	for _, alias := range aliases {
+		if err := eng.AddTopicAlias(alias, cfg.Aliases[alias]); err != nil {
+			return nil, fmt.Errorf("%w: alias %s: %v", ErrConfigInvalid, alias, err)
+		}
+	}
+
+	for _, spec := range cfg.Forwarding {
+		sources := spec.Sources
+		if len(spec.From) > 0 {
+			sources = append([]string{spec.From}, sources...)
+		}
+
+		id := spec.Id
+		if len(id) == 0 {
+			id = fmt.Sprintf("forward:%s:%s", strings.Join(sources, ","), spec.To)
+		}
+
+		rule := Forward(spec.To, sources...).FromProducer(spec.Producer)
+		if err := eng.AddForwardRule(id, rule); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrConfigInvalid, err)
+		}
+	}
+
+	for _, spec := range cfg.Modules {
+		var mod Module
+		var err error
+
+		if factory, ok := overrides[spec.Kind]; ok {
+			mod, err = factory(spec.Settings)
+		} else {
+			mod, err = NewModule(spec.Kind, spec.Settings)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("module %s: %w", spec.Kind, err)
+		}
+
+		topics := make([]*TopicCfg, 0, len(spec.Topics))
+		for _, topicSpec := range spec.Topics {
+			topic, err := topicSpec.TopicCfg()
+			if err != nil {
+				return nil, err
+			}
+			topics = append(topics, topic)
+		}
+
+		if err := eng.UseModule(mod, topics); err != nil {
+			return nil, fmt.Errorf("module %s: %w", spec.Kind, err)
+		}
+	}
+
+	return eng, nil
+}
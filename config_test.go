@@ -0,0 +1,153 @@
+package nerv
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testConfig = `{
+	"topics": [
+		{"name": "orders", "distribution": "broadcast"},
+		{"name": "orders.audit", "retention": {"max_events": 10}},
+		{"name": "workers", "distribution": "direct", "selection": "round-robin"}
+	],
+	"forwarding": [
+		{"from": "orders", "to": "orders.audit"}
+	],
+	"modules": [
+		{"kind": "ordered", "settings": {"name": "configured"}, "topics": [{"name": "configured.out"}]}
+	]
+}`
+
+func init() {
+	RegisterModuleFactory("ordered", orderedFactory)
+}
+
+func orderedFactory(settings json.RawMessage) (Module, error) {
+	var s struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(settings, &s); err != nil {
+		return nil, err
+	}
+	return &orderedModule{name: s.Name, journal: &moduleJournal{}}, nil
+}
+
+func TestConfig(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "nerv.json")
+	if err := os.WriteFile(path, []byte(testConfig), 0644); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Factories handed to the builder take precedence over the registry
+	if _, err := NewEngineFromConfig(cfg, map[string]ModuleFactory{
+		"ordered": func(settings json.RawMessage) (Module, error) {
+			return nil, errOrderedModule
+		},
+	}); !errors.Is(err, errOrderedModule) {
+		t.Fatalf("expected overriding factory to be used, got %v", err)
+	}
+
+	engine, err := NewEngineFromConfig(cfg, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for _, topic := range []string{"orders", "orders.audit", "workers", "configured.out"} {
+		if !engine.ContainsTopic(&topic) {
+			t.Fatalf("expected topic %s to be created", topic)
+		}
+	}
+
+	if err := engine.SetModuleMeta("configured", true); err != nil {
+		t.Fatalf("expected configured module, got %v", err)
+	}
+
+	var audited atomic.Int32
+	engine.Register(Consumer{
+		Id: "auditor",
+		Fn: func(event *Event) {
+			if event.Producer == "shop" && event.Id == "order-1" {
+				audited.Add(1)
+			}
+		},
+	})
+
+	if err := engine.SubscribeTo("orders.audit", "auditor"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	engine.SubmitEvent(Event{
+		Spawned:  time.Now(),
+		Topic:    "orders",
+		Producer: "shop",
+		Id:       "order-1",
+	})
+
+	time.Sleep(50 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if audited.Load() != 1 {
+		t.Fatal("expected event to be forwarded with its producer and id")
+	}
+}
+
+func TestConfigInvalid(t *testing.T) {
+
+	for _, data := range []string{
+		`{"topics": [{"name": "a", "distribution": "sideways"}]}`,
+		`{"topics": [{"name": "a", "selection": "best"}]}`,
+		`{"topics": [{"name": "a", "dedup_window": "soon"}]}`,
+		`{"topics": [{"name": "a"}], "forwarding": [{"from": "a", "to": "a"}]}`,
+		`{"modules": [{"kind": "unregistered"}]}`,
+		`{"topics": [{"name": "a", "dedup_windw": "1s"}]}`,
+		`{"restrict_producer": true}`,
+	} {
+		cfg, err := ParseConfig([]byte(data))
+		if err == nil {
+			_, err = NewEngineFromConfig(cfg, nil)
+		}
+		if err == nil {
+			t.Fatalf("expected configuration to be refused: %s", data)
+		}
+	}
+}
+
+func TestModuleRegistry(t *testing.T) {
+
+	kinds := ModuleKinds()
+	if len(kinds) != 1 || kinds[0] != "ordered" {
+		t.Fatalf("expected only the ordered kind to be registered, got %v", kinds)
+	}
+
+	mod, err := NewModule("ordered", json.RawMessage(`{"name": "runtime"}`))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if mod.GetName() != "runtime" {
+		t.Fatalf("expected module named runtime, got %s", mod.GetName())
+	}
+
+	if _, err := NewModule("unregistered", nil); !errors.Is(err, ErrConfigUnknownModuleKind) {
+		t.Fatalf("expected unknown module kind, got %v", err)
+	}
+}
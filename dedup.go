@@ -0,0 +1,59 @@
+package nerv
+
+import (
+	"time"
+)
+
+type dedupEntry struct {
+	id   string
+	seen time.Time
+}
+
+// Time-bounded record of event ids that have been submitted to a
+// topic. Entries are expired in submission order
+type dedupCache struct {
+	window time.Duration
+	seen   map[string]time.Time
+	order  []dedupEntry
+}
+
+func newDedupCache(window time.Duration) *dedupCache {
+	return &dedupCache{
+		window: window,
+		seen:   make(map[string]time.Time),
+		order:  make([]dedupEntry, 0),
+	}
+}
+
+func (d *dedupCache) expire(now time.Time) {
+	drop := 0
+	for drop < len(d.order) && now.Sub(d.order[drop].seen) > d.window {
+		entry := d.order[drop]
+		if seen, ok := d.seen[entry.id]; ok && seen.Equal(entry.seen) {
+			delete(d.seen, entry.id)
+		}
+		drop += 1
+	}
+	d.order = d.order[drop:]
+}
+
+// Record the id, indicating if it had already been
+// seen within the window
+func (d *dedupCache) check(id string) bool {
+
+	now := time.Now()
+
+	d.expire(now)
+
+	if _, ok := d.seen[id]; ok {
+		return true
+	}
+
+	d.seen[id] = now
+	d.order = append(d.order, dedupEntry{id, now})
+	return false
+}
+
+func (d *dedupCache) forget(id string) {
+	delete(d.seen, id)
+}
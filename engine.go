@@ -6,11 +6,20 @@ import (
 	"fmt"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	nervTopicInternal = "nerv.internal"
+	// Topic that the engine publishes its own events on
+	TopicInternal = "nerv.internal"
+
+	// Topic that events which could not be delivered are published on
+	TopicDeadLetter = "nerv.deadletter"
+
+	nervProducerEngine = "mmp.nerv.engine"
+
+	defaultQueueCapacity = 16384
 )
 
 var ErrEngineAlreadyRunning = errors.New("engine already running")
@@ -19,33 +28,128 @@ var ErrEngineUnknownTopic = errors.New("unknown topic")
 var ErrEngineUnknownModule = errors.New("unknown module")
 var ErrEngineUnknownConsumer = errors.New("unknown consumer")
 var ErrEngineDuplicateTopic = errors.New("duplicate topic")
+var ErrEngineDuplicateEvent = errors.New("duplicate event")
+var ErrEngineDuplicateModule = errors.New("duplicate module")
+var ErrEngineTopicDeleting = errors.New("topic is being deleted")
+var ErrEngineQueueFull = errors.New("event queue is full")
 
 type moduleMetaPair struct {
 	module Module
 	meta   interface{}
+
+	// Topics created for the module and subscriptions
+	// made through its pane, undone when it is removed
+	topics        []string
+	subscriptions []moduleSubscription
+
+	// Submissions made through the pane that are yet to happen
+	scheduled map[*time.Timer]bool
+}
+
+type moduleSubscription struct {
+	topic      string
+	consumer   string
+	registered bool
 }
 
+type queuedEvent struct {
+	event  Event
+	shaped bool
+
+	// When set, run by the dispatcher in place of delivering an event
+	apply func()
+}
+
+// How the events still in flight for a topic are handled when it is deleted
+type DeletePolicy int
+
+const (
+	// Events submitted before the deletion are delivered before the topic
+	// is removed. Submissions made while the topic drains are refused
+	DeleteDrain DeletePolicy = iota
+
+	// The topic is removed at once and the events still
+	// in flight for it are published on nerv.deadletter
+	DeleteReject
+)
+
 type Engine struct {
 	topics    map[string]*eventTopic
 	consumers map[string]EventRecvr
+	batchers  map[string]*batcher
 
 	mmp map[string]*moduleMetaPair
 
-	topicMu sync.Mutex
-	subMu   sync.Mutex
-	modMu   sync.Mutex
-	wg      sync.WaitGroup
+	// Modules in the order they were handed to the engine, and
+	// those that are started in the order they were started in
+	modOrder []string
+	started  []Module
+
+	shutdownTimeout time.Duration
+
+	supervisorCfg *SupervisorCfg
+	supervisor    *supervisor
+
+	producerLimits map[string]*RateLimiter
+	delays         *delayLine
+
+	acl *producerAcl
+
+	rules   []*forwardRule
+	aliases map[string]string
+	routes  []declaredRoute
+	routeMu sync.RWMutex
+
+	maxHops int
 
-	eventChan chan Event
+	breakers       map[string]*consumerBreaker
+	defaultBreaker *BreakerCfg
+
+	topicMu   sync.Mutex
+	subMu     sync.Mutex
+	modMu     sync.Mutex
+	limitMu   sync.Mutex
+	breakerMu sync.Mutex
+
+	// Held while events are handed to consumers
+	dispatchMu sync.Mutex
+
+	// Held while modules are being started or shut down so
+	// that supervision never races the adding or removing of one
+	lifeMu sync.Mutex
+
+	wg sync.WaitGroup
+
+	// Events waiting on the dispatcher. Submission never blocks on the
+	// dispatcher so consumers are free to submit while being delivered to.
+	// Producers are refused once queueCap events are waiting instead
+	queue    []queuedEvent
+	queueCap int
+	queueMu  sync.Mutex
+	queueSig chan struct{}
 
 	ctx    context.Context
 	cancel context.CancelFunc
 
-	running bool
+	running atomic.Bool
 
 	callbacks EngineCallbacks
+
+	name         string
+	baseLogger   *slog.Logger
+	logger       *slog.Logger
+	moduleLevels map[string]slog.Level
+	redactor     Redactor
+	logMu        sync.RWMutex
+
+	metrics engineMetrics
 }
 
+// Functions invoked in their own goroutine as the engine changes. Their
+// events are handed to them directly rather than through nerv.internal.
+//
+// Deprecated: subscribe to the nerv.internal subtopics instead, which
+// receive typed events in the order the changes happened in
 type EngineCallbacks struct {
 	RegisterCb EventRecvr
 	NewTopicCb EventRecvr
@@ -55,11 +159,25 @@ type EngineCallbacks struct {
 
 func NewEngine() *Engine {
 	eng := &Engine{
-		topics:    make(map[string]*eventTopic),
-		consumers: make(map[string]EventRecvr),
-		mmp:       make(map[string]*moduleMetaPair),
-		eventChan: make(chan Event),
-		running:   false,
+		topics:          make(map[string]*eventTopic),
+		consumers:       make(map[string]EventRecvr),
+		batchers:        make(map[string]*batcher),
+		mmp:             make(map[string]*moduleMetaPair),
+		producerLimits:  make(map[string]*RateLimiter),
+		acl:             newProducerAcl(),
+		aliases:         make(map[string]string),
+		maxHops:         defaultMaxHops,
+		breakers:        make(map[string]*consumerBreaker),
+		queue:           make([]queuedEvent, 0),
+		queueCap:        defaultQueueCapacity,
+		queueSig:        make(chan struct{}, 1),
+		modOrder:        make([]string, 0),
+		shutdownTimeout: defaultModuleShutdownTimeout,
+		name:            defaultEngineName,
+		baseLogger:      slog.Default(),
+		logger:          slog.Default().With("engine", defaultEngineName),
+		moduleLevels:    make(map[string]slog.Level),
+		redactor:        redactPayload,
 		callbacks: EngineCallbacks{
 			nil,
 			nil,
@@ -68,19 +186,18 @@ func NewEngine() *Engine {
 		},
 	}
 
+	eng.delays = newDelayLine(eng.enqueue)
+
 	eng.ctx, eng.cancel = context.WithCancel(context.Background())
 
-	eng.CreateTopic(
-		NewTopic(nervTopicInternal).
-			UsingBroadcast().
-			UsingNoSelection())
+	eng.createInternalTopics()
 	return eng
 }
 
 func (eng *Engine) WithTopics(topics []*TopicCfg) *Engine {
 	for _, topic := range topics {
 		if err := eng.CreateTopic(topic); err != nil {
-			slog.Debug("failed to create bulk topic", "topic", topic.Name, "err", err.Error())
+			eng.log().Debug("failed to create bulk topic", "topic", topic.Name, "err", err.Error())
 			panic("failed to bulk-create topics")
 		}
 		go eng.checkCallback(eng.callbacks.NewTopicCb, topic)
@@ -88,14 +205,27 @@ func (eng *Engine) WithTopics(topics []*TopicCfg) *Engine {
 	return eng
 }
 
+// Limit how many submitted events may be waiting on the dispatcher,
+// including those held back by rate limits. Producers submitting
+// while the limit is reached are refused with ErrEngineQueueFull
+func (eng *Engine) WithQueueCapacity(capacity int) *Engine {
+	eng.queueMu.Lock()
+	defer eng.queueMu.Unlock()
+
+	eng.queueCap = capacity
+	return eng
+}
+
 func (eng *Engine) WithCallbacks(cbs EngineCallbacks) *Engine {
 	eng.callbacks = cbs
 	return eng
 }
 
+// Aliases are contained if the topic they resolve to is
 func (eng *Engine) ContainsTopic(topic *string) bool {
+	resolved := eng.ResolveTopic(*topic)
 	// no guard, just read. no-exist topics filtered on emit
-	_, ok := eng.topics[*topic]
+	_, ok := eng.topics[resolved]
 	return ok
 }
 
@@ -110,12 +240,19 @@ func (eng *Engine) ContainsConsumer(id *string) bool {
 // Given the nature and purpose of Nerv, the producer handed back can be called
 // from any thread at any time worry-free as long as the engine is running
 func (eng *Engine) AddRoute(topic string, route Route) (Producer, error) {
+	return eng.AddTopicRoute(NewTopic(topic), route)
+}
+
+// Add a route whose topic is created from the given configuration, permitting
+// the route to make use of topic options such as throttling or debouncing
+func (eng *Engine) AddTopicRoute(cfg *TopicCfg, route Route) (Producer, error) {
+	topic := cfg.Name
 	routeId := fmt.Sprintf("route:%s", topic)
 	writerId := fmt.Sprintf("prod:%s", topic)
 
-	slog.Debug("add route", "topic", topic, "route-id", routeId, "producer-id", writerId)
+	eng.log().Debug("add route", "topic", topic, "route-id", routeId, "producer-id", writerId)
 
-	if err := eng.CreateTopic(NewTopic(topic)); err != nil {
+	if err := eng.CreateTopic(cfg); err != nil {
 		return nil, err
 	}
 
@@ -160,28 +297,56 @@ func (eng *Engine) GetModuleMeta(name string) interface{} {
 
 	mmp, ok := eng.mmp[name]
 	if !ok {
-		slog.Warn("attempt to retrieve meta for unknown module", "module", name)
+		eng.log().Warn("attempt to retrieve meta for unknown module", "module", name)
+		return nil
 	}
-	// defaults to nil so w/e
 	return mmp.meta
 }
 
+// Start the modules in dependency order and then the dispatcher. Should
+// a module fail to start, the modules already started are shut down and
+// the engine is left stopped
 func (eng *Engine) Start() error {
 
-	slog.Debug("Start", "running", eng.running)
+	eng.log().Debug("Start", "running", eng.running.Load())
 
-	if eng.running {
+	if !eng.running.CompareAndSwap(false, true) {
 		return ErrEngineAlreadyRunning
 	}
 
-	eng.wg.Add(1)
+	eng.announceEngine(EngineStarting, nil)
+
+	// Cycles may be intended, such as a stream that filters
+	// what it feeds back, so they are only warned of here
+	if err := eng.CheckTopology(); err != nil {
+		eng.log().Warn("topology contains a cycle", "err", err.Error())
+	}
 
-	eng.running = true
+	// Events submitted by modules while they start are
+	// queued until the dispatcher begins below. Should they
+	// fail, only the engine's own events are kept
+	if err := eng.startModules(); err != nil {
+		eng.queueMu.Lock()
+		retained := eng.queue[:0]
+		for _, item := range eng.queue {
+			if item.event.Producer == nervProducerEngine {
+				retained = append(retained, item)
+			}
+		}
+		clear(eng.queue[len(retained):])
+		eng.queue = retained
+		eng.queueMu.Unlock()
+		eng.announceEngine(EngineStopped, err)
+		eng.running.Store(false)
+		return err
+	}
+
+	eng.wg.Add(1)
 
 	go func() {
 
 		defer func() {
-			eng.running = false
+			eng.running.Store(false)
 		}()
 
 		defer eng.wg.Done()
@@ -189,86 +354,339 @@ func (eng *Engine) Start() error {
 		for {
 			select {
 			case <-eng.ctx.Done():
+				// Deliver what was published while stopping
+				eng.drain()
 				return
-			case event := <-eng.eventChan:
-				if len(event.Topic) > 0 {
-					eng.emitEvent(&event)
-				}
+			case <-eng.queueSig:
+				eng.drain()
 			}
 		}
 	}()
 
-	for name, mmp := range eng.mmp {
-		hasMeta := mmp.meta == nil
-		slog.Debug("indicating start to module", "module", name, "has_meta", hasMeta)
-		mmp.module.Start()
+	if eng.supervisorCfg != nil {
+		eng.supervisor = newSupervisor(eng, *eng.supervisorCfg)
+		go eng.supervisor.run()
 	}
 
+	eng.announceEngine(EngineRunning, nil)
 	return nil
 }
 
+func (eng *Engine) drain() {
+	for {
+		item, ok := eng.dequeue()
+		if !ok {
+			return
+		}
+		if item.apply != nil {
+			item.apply()
+			continue
+		}
+		if len(item.event.Topic) > 0 {
+			eng.emitEvent(&item.event, item.shaped)
+		}
+	}
+}
+
+// Shut down the modules in the reverse of the order they were started
+// in and then the dispatcher. Modules that don't shut down within the
+// shutdown timeout are reported in the returned error
 func (eng *Engine) Stop() error {
 
-	slog.Debug("Stop", "running", eng.running)
+	eng.log().Debug("Stop", "running", eng.running.Load())
 
-	if !eng.running {
+	if !eng.running.Load() {
 		return ErrEngineNotRunning
 	}
 
-	for name, mmp := range eng.mmp {
-		slog.Debug("indicating shutdown to module", "module", name)
-		mmp.module.Shutdown()
+	eng.announceEngine(EngineStopping, nil)
+
+	if eng.supervisor != nil {
+		eng.supervisor.stop()
+		eng.supervisor = nil
 	}
 
-	close(eng.eventChan)
+	eng.lifeMu.Lock()
+	eng.modMu.Lock()
+	started := eng.started
+	eng.started = nil
+	eng.modMu.Unlock()
+
+	err := eng.shutdownModules(started)
+	eng.lifeMu.Unlock()
+
+	// Events held back by rate limits are delivered rather than lost
+	eng.delays.flush()
 
 	eng.cancel()
 
 	eng.wg.Wait()
 
-	return nil
+	eng.flushBatches()
+
+	// The dispatcher is gone, so the last word is delivered directly
+	eng.emitEvent(&Event{
+		Spawned:  time.Now(),
+		Topic:    TopicInternalEngine,
+		Producer: nervProducerEngine,
+		Data:     eng.engineLifecycle(EngineStopped, err),
+	}, false)
+
+	return err
 }
 
 func (eng *Engine) checkCallback(fn EventRecvr, data interface{}) {
 	if fn != nil {
 		fn(&Event{
-			time.Now(),
-			"nerv.internal",
-			"mmp.nerv.engine",
-			data,
+			Spawned:  time.Now(),
+			Topic:    TopicInternal,
+			Producer: nervProducerEngine,
+			Data:     data,
 		})
 	}
 }
 
 func (eng *Engine) Submit(id string, topic string, data interface{}) error {
 	return eng.SubmitEvent(Event{
-		time.Now(),
-		topic,
-		id,
-		data,
+		Spawned:  time.Now(),
+		Topic:    topic,
+		Producer: id,
+		Data:     data,
 	})
 }
 
+// Submit an event, provided its producer is permitted to publish to its topic
 func (eng *Engine) SubmitEvent(event Event) error {
+	return eng.submitEvent(event, true)
+}
+
+// Submit an event, skipping the producer check and the queue's capacity
+// for events the engine submits on behalf of producers already checked
+func (eng *Engine) submitEvent(event Event, authorize bool) error {
 
-	slog.Debug("SubmitEvent", "topic", event.Topic, "producer", event.Producer)
-	if !eng.running {
+	eng.log().Debug("SubmitEvent", "topic", event.Topic, "producer", event.Producer)
+	if !eng.running.Load() {
 		return ErrEngineNotRunning
 	}
 
-	eng.eventChan <- event
+	event.Topic = eng.ResolveTopic(event.Topic)
+
+	carryHop(&event)
+
+	if authorize {
+		if err := eng.Permitted(event.Producer, event.Topic); err != nil {
+			eng.log().Debug("refusing event from producer", "topic", event.Topic, "producer", event.Producer, "err", err.Error())
+			return err
+		}
+	}
+
+	// Only events the producer may submit are dead lettered
+	if eng.dropLooping(&event) {
+		return ErrEngineHopLimit
+	}
+
+	if authorize && eng.queueFull() {
+		eng.log().Debug("refusing event while queue is full", "topic", event.Topic, "producer", event.Producer)
+		return ErrEngineQueueFull
+	}
+
+	// Duplicates are dropped before they can spend rate limit tokens
+	if eng.isDuplicate(&event) {
+		eng.log().Debug("dropping duplicate event", "topic", event.Topic, "id", event.Id)
+		eng.metrics.deduplicated.Add(1)
+		return ErrEngineDuplicateEvent
+	}
+
+	wait, admitted, err := eng.admit(&event)
+	if !admitted {
+		eng.forgetId(&event)
+		return err
+	}
+
+	eng.metrics.submitted.Add(1)
+
+	if wait > 0 {
+		eng.delays.hold(queuedEvent{event: event}, wait)
+	} else {
+		eng.enqueue(queuedEvent{event: event})
+	}
 
 	go eng.checkCallback(eng.callbacks.SubmitCb, &event)
 	return nil
 }
 
+// Limit the rate at which a producer may submit events to any topic
+func (eng *Engine) SetProducerRateLimit(producer string, limit RateLimit) {
+	eng.limitMu.Lock()
+	defer eng.limitMu.Unlock()
+
+	eng.producerLimits[producer] = NewRateLimiter(limit)
+}
+
+func (eng *Engine) RemoveProducerRateLimit(producer string) {
+	eng.limitMu.Lock()
+	defer eng.limitMu.Unlock()
+
+	delete(eng.producerLimits, producer)
+}
+
+// Apply the producer and topic rate limits to an event. Events delayed by
+// a limit are admitted along with how long they must be held back for, which
+// is done by the engine so that neither the caller nor the dispatcher waits.
+// Dropped events are not an error to the caller, rejected ones are
+func (eng *Engine) admit(event *Event) (time.Duration, bool, error) {
+
+	eng.limitMu.Lock()
+	producerLimiter := eng.producerLimits[event.Producer]
+	eng.limitMu.Unlock()
+
+	eng.topicMu.Lock()
+	var topicLimiter *RateLimiter
+	if topic, tok := eng.topics[event.Topic]; tok {
+		if topic.deleting {
+			eng.topicMu.Unlock()
+			return 0, false, ErrEngineTopicDeleting
+		}
+		topicLimiter = topic.limiter
+	}
+	eng.topicMu.Unlock()
+
+	var wait time.Duration
+	var reserved []*RateLimiter
+
+	for _, limiter := range []*RateLimiter{producerLimiter, topicLimiter} {
+		if limiter == nil {
+			continue
+		}
+
+		delay, ok := limiter.reserve()
+		if ok {
+			wait = max(wait, delay)
+			reserved = append(reserved, limiter)
+			continue
+		}
+
+		// Tokens taken by the limits the event passed are put
+		// back, as the event never makes it onto the bus
+		for _, other := range reserved {
+			other.refund()
+		}
+
+		eng.metrics.rateLimited.Add(1)
+
+		if limiter.Policy() == RateLimitReject {
+			eng.log().Debug("rejecting rate limited event", "topic", event.Topic, "producer", event.Producer)
+			return 0, false, ErrEngineRateLimited
+		}
+
+		eng.log().Debug("dropping rate limited event", "topic", event.Topic, "producer", event.Producer)
+		return 0, false, nil
+	}
+
+	if wait > 0 {
+		eng.log().Debug("delaying rate limited event", "topic", event.Topic, "producer", event.Producer, "wait", wait)
+	}
+	return wait, true, nil
+}
+
+func (eng *Engine) enqueue(item queuedEvent) {
+	eng.queueMu.Lock()
+	eng.queue = append(eng.queue, item)
+	eng.queueMu.Unlock()
+
+	select {
+	case eng.queueSig <- struct{}{}:
+	default:
+	}
+}
+
+func (eng *Engine) queueFull() bool {
+	held := eng.delays.len()
+
+	eng.queueMu.Lock()
+	defer eng.queueMu.Unlock()
+
+	return eng.queueCap > 0 && len(eng.queue)+held >= eng.queueCap
+}
+
+func (eng *Engine) dequeue() (queuedEvent, bool) {
+	eng.queueMu.Lock()
+	defer eng.queueMu.Unlock()
+
+	if len(eng.queue) == 0 {
+		return queuedEvent{}, false
+	}
+
+	item := eng.queue[0]
+	eng.queue[0] = queuedEvent{}
+	eng.queue = eng.queue[1:]
+	return item, true
+}
+
+// Place an event from the engine itself onto the bus. Internal events
+// bypass submission limits and may be published from within a delivery
+func (eng *Engine) publishInternal(topic string, data interface{}) {
+	eng.enqueue(queuedEvent{
+		event: Event{
+			Spawned:  time.Now(),
+			Topic:    topic,
+			Producer: nervProducerEngine,
+			Data:     data,
+		},
+	})
+}
+
+// Place an event held back by a topic's shaping
+// operators onto the bus for delivery
+func (eng *Engine) releaseShaped(event *Event) {
+	eng.enqueue(queuedEvent{
+		event:  *event,
+		shaped: true,
+	})
+}
+
+func (eng *Engine) isDuplicate(event *Event) bool {
+
+	if len(event.Id) == 0 {
+		return false
+	}
+
+	eng.topicMu.Lock()
+	defer eng.topicMu.Unlock()
+
+	topic, tok := eng.topics[event.Topic]
+	if !tok || topic.dedup == nil {
+		return false
+	}
+
+	return topic.dedup.check(event.Id)
+}
+
+// Forget the id of an event that was never admitted,
+// so that it may be submitted again without being
+// mistaken for a duplicate
+func (eng *Engine) forgetId(event *Event) {
+
+	if len(event.Id) == 0 {
+		return
+	}
+
+	eng.topicMu.Lock()
+	defer eng.topicMu.Unlock()
+
+	if topic, tok := eng.topics[event.Topic]; tok && topic.dedup != nil {
+		topic.dedup.forget(event.Id)
+	}
+}
+
 func (eng *Engine) Register(sub Consumer) {
-	slog.Debug("Register", "consumer", sub.Id)
+	eng.log().Debug("Register", "consumer", sub.Id)
 
 	eng.subMu.Lock()
 	defer eng.subMu.Unlock()
 
 	eng.consumers[sub.Id] = sub.Fn
+	eng.announceConsumer(sub.Id, "", ConsumerRegistered)
 
 	go eng.checkCallback(eng.callbacks.RegisterCb, &sub)
 	return
@@ -276,7 +694,11 @@ func (eng *Engine) Register(sub Consumer) {
 
 func (eng *Engine) CreateTopic(cfg *TopicCfg) error {
 
-	slog.Debug("CreateTopic", "name", cfg.Name, "tx", cfg.DistType, "sel", cfg.SelectionType)
+	eng.log().Debug("CreateTopic", "name", cfg.Name, "tx", cfg.DistType, "sel", cfg.SelectionType)
+
+	if eng.ResolveTopic(cfg.Name) != cfg.Name {
+		return ErrEngineDuplicateTopic
+	}
 
 	eng.topicMu.Lock()
 	defer eng.topicMu.Unlock()
@@ -285,30 +707,138 @@ func (eng *Engine) CreateTopic(cfg *TopicCfg) error {
 	if ok {
 		return ErrEngineDuplicateTopic
 	}
-	eng.topics[cfg.Name] = &eventTopic{
+	topic := &eventTopic{
 		distributionType: cfg.DistType,
 		selectionType:    cfg.SelectionType,
-		subscribed:       make([]EventRecvr, 0),
+		subscribed:       make([]*subscriber, 0),
+	}
+
+	if cfg.Log != nil {
+		topic.log = newTopicLog(*cfg.Log)
 	}
 
+	if cfg.RateLimit != nil {
+		topic.limiter = NewRateLimiter(*cfg.RateLimit)
+	}
+
+	if cfg.Shaping != nil {
+		topic.shaper = newTopicShaper(*cfg.Shaping)
+	}
+
+	if cfg.DedupWindow > 0 {
+		topic.dedup = newDedupCache(cfg.DedupWindow)
+	}
+
+	if cfg.LastValues > 0 {
+		topic.lastValueCap = cfg.LastValues
+		topic.lastValues = make([]*Event, 0, cfg.LastValues)
+	}
+
+	eng.topics[cfg.Name] = topic
+	eng.announceTopic(cfg.Name, TopicCreated)
+
 	go eng.checkCallback(eng.callbacks.NewTopicCb, cfg)
 	return nil
 }
 
+// Switch a topic's distribution and selection while it is in use. The
+// topic's other options are kept as they were when it was created
+func (eng *Engine) UpdateTopic(cfg *TopicCfg) error {
+
+	eng.log().Debug("UpdateTopic", "name", cfg.Name, "tx", cfg.DistType, "sel", cfg.SelectionType)
+
+	eng.topicMu.Lock()
+	defer eng.topicMu.Unlock()
+
+	topic, ok := eng.topics[cfg.Name]
+	if !ok {
+		return ErrEngineUnknownTopic
+	}
+
+	if topic.deleting {
+		return ErrEngineTopicDeleting
+	}
+
+	topic.distributionType = cfg.DistType
+	topic.selectionType = cfg.SelectionType
+
+	topic.rrMu.Lock()
+	topic.rrIdx = 0
+	for _, g := range topic.groups {
+		g.rrIdx = 0
+	}
+	topic.rrMu.Unlock()
+
+	eng.announceTopic(cfg.Name, TopicUpdated)
+	return nil
+}
+
+// Delete a topic at once. Events still in flight for it are dead lettered
 func (eng *Engine) DeleteTopic(topicId string) {
+	if err := eng.DeleteTopicWith(topicId, DeleteReject); err != nil {
+		eng.log().Debug("DeleteTopic", "name", topicId, "err", err.Error())
+	}
+}
+
+// Delete a topic, handling the events still in flight for it as the policy
+// dictates. Subscribers are told on nerv.internal.consumers that they were
+// unsubscribed once the topic is removed
+func (eng *Engine) DeleteTopicWith(topicId string, policy DeletePolicy) error {
 
-	slog.Debug("DeleteTopic", "name", topicId)
+	eng.log().Debug("DeleteTopicWith", "name", topicId, "policy", policy)
 
 	eng.topicMu.Lock()
 	defer eng.topicMu.Unlock()
 
+	topic, ok := eng.topics[topicId]
+	if !ok || topic.deleting {
+		return ErrEngineUnknownTopic
+	}
+
+	if policy == DeleteReject || !eng.running.Load() {
+		eng.removeTopic(topicId)
+		return nil
+	}
+
+	// Everything queued before the removal has been
+	// dispatched by the time the dispatcher reaches it
+	topic.deleting = true
+	eng.announceTopic(topicId, TopicDraining)
+	eng.enqueue(queuedEvent{
+		apply: func() {
+			eng.topicMu.Lock()
+			defer eng.topicMu.Unlock()
+			eng.removeTopic(topicId)
+		},
+	})
+	return nil
+}
+
+// Expects eng.topicMu to be held
+func (eng *Engine) removeTopic(topicId string) {
+
+	topic, ok := eng.topics[topicId]
+	if !ok {
+		return
+	}
+
 	delete(eng.topics, topicId)
+
+	for _, sub := range topic.subscribed {
+		eng.announceConsumer(sub.id, topicId, ConsumerUnsubscribed)
+	}
+	for _, g := range topic.groups {
+		for _, member := range g.members {
+			eng.announceMember(member.id, topicId, g.name, ConsumerUnsubscribed)
+		}
+	}
+	eng.announceTopic(topicId, TopicDeleted)
 }
 
 // Does not check for duplicate subscriptions
 func (eng *Engine) SubscribeTo(topicId string, consumers ...string) error {
 
-	slog.Debug("SubscribeTo", "topic", topicId)
+	eng.log().Debug("SubscribeTo", "topic", topicId)
 
 	for _, s := range consumers {
 		if err := eng.subscribeTo(topicId, s); err != nil {
@@ -319,35 +849,349 @@ func (eng *Engine) SubscribeTo(topicId string, consumers ...string) error {
 }
 
 func (eng *Engine) subscribeTo(topicId string, subId string) error {
+	return eng.subscribeFrom(topicId, subId, FromLatest())
+}
+
+// Subscribe a set of consumers to a topic, first handing each of them the
+// events retained in the topic's log from the given position onward. While
+// the engine runs the history is handed over by the dispatcher, so no live
+// event is received before it, and the subscription is in place ahead of
+// any event submitted after the call. Consumers may subscribe others this
+// way while they are being delivered to
+func (eng *Engine) SubscribeFrom(topicId string, pos StartPosition, consumers ...string) error {
 
+	eng.log().Debug("SubscribeFrom", "topic", topicId, "position", pos.kind)
+
+	for _, s := range consumers {
+		if err := eng.subscribeFrom(topicId, s, pos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (eng *Engine) subscribeFrom(topicId string, subId string, pos StartPosition) error {
+
+	if !eng.replays(topicId, pos) || !eng.running.Load() {
+		return eng.replayTo(topicId, subId, pos)
+	}
+
+	// Problems are reported now, even though the
+	// subscription is made once the dispatcher gets to it
 	eng.subMu.Lock()
-	defer eng.subMu.Unlock()
+	eng.topicMu.Lock()
+	_, _, err := eng.attachable(topicId, subId, pos)
+	eng.topicMu.Unlock()
+	eng.subMu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	eng.enqueue(queuedEvent{
+		apply: func() {
+			if err := eng.replayTo(topicId, subId, pos); err != nil {
+				eng.log().Debug("failed to subscribe", "topic", topicId, "consumer", subId, "err", err.Error())
+			}
+		},
+	})
+	return nil
+}
+
+// Subscribe the consumer, handing it the events it must receive before any
+// live event. Expects to be called by the dispatcher or before it has started
+func (eng *Engine) replayTo(topicId string, subId string, pos StartPosition) error {
+
+	sub, history, err := eng.attach(topicId, subId, pos)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range history {
+		event.delivered()
+		eng.deliver(sub, event)
+	}
+
+	info := fmt.Sprintf("%s:%s", topicId, subId)
+	go eng.checkCallback(eng.callbacks.ConsumeCb, &info)
+	return nil
+}
+
+func (eng *Engine) replays(topicId string, pos StartPosition) bool {
+	if pos.kind != startLatest {
+		return true
+	}
 
 	eng.topicMu.Lock()
 	defer eng.topicMu.Unlock()
 
+	topic, tok := eng.topics[topicId]
+	return tok && topic.lastValueCap > 0
+}
+
+// Retrieve the consumer and topic of a subscription, provided it can
+// be made. Expects eng.subMu and eng.topicMu to be held
+func (eng *Engine) attachable(topicId string, subId string, pos StartPosition) (EventRecvr, *eventTopic, error) {
+
 	subscribedFn, aok := eng.consumers[subId]
 	if !aok {
-		return ErrEngineUnknownConsumer
+		return nil, nil, ErrEngineUnknownConsumer
+	}
+
+	topic, tok := eng.topics[topicId]
+	if !tok {
+		return nil, nil, ErrEngineUnknownTopic
+	}
+
+	if pos.kind != startLatest && topic.log == nil {
+		return nil, nil, ErrTopicNotLogged
+	}
+	return subscribedFn, topic, nil
+}
+
+// Add the consumer to the topic's subscribers, retrieving
+// the events it must be handed before any live event
+func (eng *Engine) attach(topicId string, subId string, pos StartPosition) (*subscriber, []*Event, error) {
+
+	eng.subMu.Lock()
+	defer eng.subMu.Unlock()
+
+	eng.topicMu.Lock()
+	defer eng.topicMu.Unlock()
+
+	subscribedFn, topic, err := eng.attachable(topicId, subId, pos)
+	if err != nil {
+		return nil, nil, err
 	}
 
+	var history []*Event
+	if pos.kind != startLatest {
+		history = topic.log.from(pos)
+	} else {
+		for _, event := range topic.lastValues {
+			history = append(history, event.clone())
+		}
+	}
+
+	sub := &subscriber{
+		id: subId,
+		fn: subscribedFn,
+	}
+
+	topic.subscribed = append(topic.subscribed, sub)
+	eng.announceConsumer(subId, topicId, ConsumerSubscribed)
+	return sub, history, nil
+}
+
+// Remove a set of consumers from a topic's subscribers and its groups
+func (eng *Engine) Unsubscribe(topicId string, consumers ...string) error {
+
+	eng.log().Debug("Unsubscribe", "topic", topicId)
+
+	eng.topicMu.Lock()
+	defer eng.topicMu.Unlock()
+
 	topic, tok := eng.topics[topicId]
 	if !tok {
 		return ErrEngineUnknownTopic
 	}
 
-	topic.subscribed = append(topic.subscribed, subscribedFn)
+	for _, id := range consumers {
+		if !eng.detach(topicId, topic, id) {
+			return ErrEngineUnknownConsumer
+		}
+	}
+	return nil
+}
+
+// Remove a consumer from a topic's subscribers and groups, indicating
+// whether it was found in either. Expects eng.topicMu to be held
+func (eng *Engine) detach(topicId string, topic *eventTopic, id string) bool {
 
-	eng.topics[topicId] = topic
+	retained := make([]*subscriber, 0, len(topic.subscribed))
+	for _, sub := range topic.subscribed {
+		if sub.id != id {
+			retained = append(retained, sub)
+		}
+	}
+	subscribed := len(retained) != len(topic.subscribed)
+	groups := topic.leaveGroups(id)
 
-	info := fmt.Sprintf("%s:%s", topicId, subId)
-	go eng.checkCallback(eng.callbacks.ConsumeCb, &info)
+	if !subscribed && len(groups) == 0 {
+		return false
+	}
+
+	topic.subscribed = retained
+	if subscribed {
+		eng.announceConsumer(id, topicId, ConsumerUnsubscribed)
+	}
+	for _, group := range groups {
+		eng.announceMember(id, topicId, group, ConsumerUnsubscribed)
+	}
+	return true
+}
+
+// Forget a consumer, unsubscribing it from every topic. Batch
+// consumers are handed whatever remains of their batch
+func (eng *Engine) Deregister(id string) error {
+
+	eng.log().Debug("Deregister", "consumer", id)
+
+	eng.subMu.Lock()
+	_, ok := eng.consumers[id]
+	eng.subMu.Unlock()
+
+	if !ok {
+		return ErrEngineUnknownConsumer
+	}
+
+	eng.topicMu.Lock()
+	for topicId, topic := range eng.topics {
+		eng.detach(topicId, topic, id)
+	}
+	eng.topicMu.Unlock()
+
+	eng.deregister(id)
 	return nil
 }
 
-func (eng *Engine) emitEvent(event *Event) {
+func (eng *Engine) deregister(id string) {
+	eng.subMu.Lock()
+
+	if _, ok := eng.consumers[id]; !ok {
+		eng.subMu.Unlock()
+		return
+	}
+
+	delete(eng.consumers, id)
+	eng.announceConsumer(id, "", ConsumerDeregistered)
+
+	b, batched := eng.batchers[id]
+	delete(eng.batchers, id)
+	eng.subMu.Unlock()
+
+	if batched {
+		b.flush()
+	}
+}
 
-	slog.Debug("emitEvent", "topic", event.Topic, "producer", event.Producer)
+// Retrieve the offset of the oldest event retained by a topic's
+// log along with the offset that the next event will be given
+func (eng *Engine) TopicOffsets(topicId string) (uint64, uint64, error) {
+
+	eng.topicMu.Lock()
+	defer eng.topicMu.Unlock()
+
+	topic, tok := eng.topics[topicId]
+	if !tok {
+		return 0, 0, ErrEngineUnknownTopic
+	}
+
+	if topic.log == nil {
+		return 0, 0, ErrTopicNotLogged
+	}
+
+	earliest, next := topic.log.bounds()
+	return earliest, next, nil
+}
+
+// Retrieve the most recent event submitted to a topic configured
+// with a last value cache
+func (eng *Engine) LastEvent(topicId string) (*Event, error) {
+
+	events, err := eng.LastEvents(topicId)
+	if err != nil {
+		return nil, err
+	}
+	return events[len(events)-1], nil
+}
+
+// Retrieve copies of the events held by a topic's last value cache, oldest first
+func (eng *Engine) LastEvents(topicId string) ([]*Event, error) {
+
+	eng.topicMu.Lock()
+	defer eng.topicMu.Unlock()
+
+	topic, tok := eng.topics[topicId]
+	if !tok {
+		return nil, ErrEngineUnknownTopic
+	}
+
+	if len(topic.lastValues) == 0 {
+		return nil, ErrTopicNoLastValue
+	}
+
+	events := make([]*Event, 0, len(topic.lastValues))
+	for _, event := range topic.lastValues {
+		events = append(events, event.clone())
+	}
+	return events, nil
+}
+
+// Discard all events in a topic's log that have been
+// superseded by a later event with the same compaction key
+func (eng *Engine) CompactTopic(topicId string) error {
+
+	eng.topicMu.Lock()
+	defer eng.topicMu.Unlock()
+
+	topic, tok := eng.topics[topicId]
+	if !tok {
+		return ErrEngineUnknownTopic
+	}
+
+	if topic.log == nil {
+		return ErrTopicNotLogged
+	}
+
+	topic.log.compact()
+	return nil
+}
+
+// Hand an event to the consumers of its topic. Events that have already
+// passed through the topic's shaping operators are not logged or shaped again.
+// Consumers are selected under lock but delivered to without it so that
+// they are free to submit events and subscribe consumers of their own
+func (eng *Engine) emitEvent(event *Event, shaped bool) {
+
+	eng.log().Debug("emitEvent", "topic", event.Topic, "producer", event.Producer)
+
+	eng.dispatchMu.Lock()
+	defer eng.dispatchMu.Unlock()
+
+	recipients, emitted := eng.selectRecipients(event, shaped)
+
+	if emitted {
+		eng.applyForwarding(event)
+	}
+
+	if len(recipients) == 0 {
+		return
+	}
+
+	// Marked once, before any consumer can see it
+	event.delivered()
+
+	if len(recipients) == 1 {
+		eng.deliver(recipients[0], event)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, recipient := range recipients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			eng.deliver(recipient, event)
+		}()
+	}
+	wg.Wait()
+}
+
+// Select the consumers an event is handed to. Events that are new to the
+// topic, rather than released by its shaping operators, are reported as
+// emitted so that they are forwarded once
+func (eng *Engine) selectRecipients(event *Event, shaped bool) ([]*subscriber, bool) {
 
 	eng.subMu.Lock()
 	defer eng.subMu.Unlock()
@@ -357,132 +1201,138 @@ func (eng *Engine) emitEvent(event *Event) {
 
 	topic, tok := eng.topics[event.Topic]
 	if !tok {
-		slog.Warn("unknown topic")
-		return
+		eng.log().Warn("unknown topic", "topic", event.Topic)
+		eng.deadLetter(nil, event, ErrEngineUnknownTopic.Error())
+		return nil, false
 	}
 
-	if !topic.hasSubscriber() {
-		slog.Debug("no consumers for event topic", "topic", event.Topic, "origin", event.Producer)
-		return
+	if !shaped {
+		if topic.log != nil {
+			topic.log.append(event)
+		}
+
+		topic.cacheLastValue(event)
+
+		eng.metrics.emitted.Add(1)
+
+		if topic.shaper != nil && !topic.shaper.admit(event, eng.releaseShaped) {
+			return nil, true
+		}
 	}
 
-	switch topic.distributionType {
-	case distBroadcast:
-		publishBroadcast(event, topic)
-		return
-	case distDirect:
-		publishDirect(event, topic)
-		return
+	if !topic.hasSubscriber() && len(topic.groups) == 0 {
+		eng.log().Debug("no consumers for event topic", "topic", event.Topic, "origin", event.Producer)
+		return nil, !shaped
+	}
+
+	var recipients []*subscriber
+
+	if topic.hasSubscriber() {
+		switch topic.distributionType {
+		case distBroadcast:
+			recipients = eng.selectBroadcast(event, topic)
+		case distDirect:
+			recipients = eng.selectDirect(event, topic)
+		default:
+			eng.log().Warn("unknown distribution type", "dist", topic.distributionType)
+		}
 	}
 
-	slog.Warn("unknown distribution type", "dist", topic.distributionType)
+	return append(recipients, eng.selectGroups(event, topic)...), !shaped
 }
 
-func publishBroadcast(event *Event, topic *eventTopic) {
-	slog.Debug("broadcast")
+func (eng *Engine) selectBroadcast(event *Event, topic *eventTopic) []*subscriber {
+	eng.log().Debug("broadcast")
 
-	var wg sync.WaitGroup
+	recipients := make([]*subscriber, 0, len(topic.subscribed))
 	for _, consumer := range topic.subscribed {
 		if consumer == nil {
 			continue
 		}
 
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			consumer(event)
-		}()
+		if !eng.available(consumer) {
+			eng.deadLetter(consumer, event, "circuit open")
+			continue
+		}
+
+		recipients = append(recipients, consumer)
 	}
-	wg.Wait()
+	return recipients
 }
 
-func validateId(idx int, consumers []EventRecvr) bool {
+func (eng *Engine) validateId(idx int, consumers []*subscriber) bool {
 	if idx < 0 || idx >= len(consumers) {
-		slog.Warn("invalid idx", "idx", idx)
+		eng.log().Warn("invalid idx", "idx", idx)
 		return false
 	}
 	if consumers[idx] == nil {
-		slog.Warn("nil idx for selection")
+		eng.log().Warn("nil idx for selection")
 		return false
 	}
 	return true
 }
 
-func publishDirect(event *Event, topic *eventTopic) {
+func (eng *Engine) selectDirect(event *Event, topic *eventTopic) []*subscriber {
+
+	var idx int
+	var err error
 
 	switch topic.selectionType {
 	case selectArbitrary:
-		slog.Debug("direct", "method", "arbitrary")
-		for _, s := range topic.subscribed {
-			if s != nil {
-				s(event)
-				return
-			} else {
-				slog.Warn("unable to find valid consumer for arbitrary selection")
-				return
-			}
-		}
-		return
+		eng.log().Debug("direct", "method", "arbitrary")
+		idx, err = topic.firstSubscriber(eng.available)
 	case selectRoundRobin:
-		slog.Debug("direct", "method", "round robin")
-		idx, err := topic.rrNext()
-		if err != nil {
-			slog.Warn(err.Error())
-			return
-		}
-		if !validateId(idx, topic.subscribed) {
-			return
-		}
-		topic.subscribed[idx](event)
-		return
+		eng.log().Debug("direct", "method", "round robin")
+		idx, err = topic.rrNext(eng.available)
 	case selectRandom:
-		slog.Debug("direct", "method", "random")
-		idx, err := topic.randomSubscriber()
-		if err != nil {
-			slog.Warn(err.Error())
-			return
-		}
-		if !validateId(idx, topic.subscribed) {
-			return
-		}
-		slog.Debug("dest", "idx", idx)
-		topic.subscribed[idx](event)
-		return
+		eng.log().Debug("direct", "method", "random")
+		idx, err = topic.randomSubscriber(eng.available)
+	default:
+		eng.log().Warn("invalid selection type", "selection type", topic.selectionType)
+		return nil
 	}
-	slog.Warn("invalid selection type", "selection type", topic.selectionType)
+
+	if err != nil {
+		eng.log().Warn(err.Error(), "topic", event.Topic)
+		eng.deadLetter(nil, event, err.Error())
+		return nil
+	}
+
+	if !eng.validateId(idx, topic.subscribed) {
+		return nil
+	}
+
+	eng.log().Debug("dest", "idx", idx)
+	return []*subscriber{topic.subscribed[idx]}
 }
 
+// Hand a module to the engine, creating the topics it publishes on. Should
+// the engine already be running the module is started immediately, and is
+// removed again if it fails to start
 func (eng *Engine) UseModule(
 	mod Module,
-	topics []*TopicCfg) {
+	topics []*TopicCfg) error {
+
+	eng.log().Debug("setting up module", "name", mod.GetName())
+
+	running := eng.running.Load()
 
 	eng.modMu.Lock()
-	defer eng.modMu.Unlock()
+	_, exists := eng.mmp[mod.GetName()]
+	eng.modMu.Unlock()
 
-	slog.Debug("setting up module", "name", mod.GetName())
+	if exists && running {
+		return ErrEngineDuplicateModule
+	}
 
-	modp := ModulePane{
-		SubmitEvent: func(event *Event) {
-			eng.SubmitEvent(*event)
-		},
-		SubmitTo: func(topic string, data interface{}) {
-			eng.Submit(
-				mod.GetName(),
-				topic,
-				data)
-		},
-		SubscribeTo: func(topicName string, consumers []Consumer, performRegistration bool) error {
-			for _, consumer := range consumers {
-				if performRegistration {
-					eng.Register(consumer)
-				}
-				if err := eng.subscribeTo(topicName, consumer.Id); err != nil {
-					return err
-				}
-			}
-			return nil
-		},
-		GetModuleMeta: eng.GetModuleMeta,
+	// The module may use its pane as soon as it receives it, so
+	// modMu is not held while calling into the engine or the module
+	entry := &moduleMetaPair{
+		module:        mod,
+		meta:          nil,
+		topics:        make([]string, 0),
+		subscriptions: make([]moduleSubscription, 0),
+		scheduled:     make(map[*time.Timer]bool),
 	}
 
 	for _, topic := range topics {
@@ -490,17 +1340,32 @@ func (eng *Engine) UseModule(
 		// and ensure that the module has a unique name
 		if err := eng.CreateTopic(topic); err != nil {
 			if errors.Is(err, ErrEngineDuplicateTopic) {
-				slog.Info("topic has already been created", "name", topic.Name)
+				eng.log().Info("topic has already been created", "name", topic.Name)
 			} else {
 				panic("error creating topic for module")
 			}
+			continue
 		}
+		entry.topics = append(entry.topics, topic.Name)
 	}
 
-	mod.RecvModulePane(&modp)
+	mod.RecvModulePane(eng.newModulePane(mod.GetName(), entry))
+
+	eng.modMu.Lock()
+
+	if _, ok := eng.mmp[mod.GetName()]; !ok {
+		eng.modOrder = append(eng.modOrder, mod.GetName())
+	} else if running {
+		eng.modMu.Unlock()
+		return ErrEngineDuplicateModule
+	}
+
+	eng.mmp[mod.GetName()] = entry
+
+	eng.modMu.Unlock()
 
-	eng.mmp[mod.GetName()] = &moduleMetaPair{
-		module: mod,
-		meta:   nil,
+	if !running {
+		return nil
 	}
+	return eng.startModule(mod)
 }
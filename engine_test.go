@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"os"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -519,3 +520,801 @@ func TestRouting(t *testing.T) {
 		}
 	}
 }
+
+func TestLastValueCache(t *testing.T) {
+
+	topicName := "status"
+
+	engine := NewEngine()
+
+	if err := engine.CreateTopic(
+		NewTopic(topicName).
+			UsingBroadcast().
+			UsingLastValueCache(2)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	if _, err := engine.LastEvent(topicName); err != ErrTopicNoLastValue {
+		t.Fatalf("expected no last value before submission, got %v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 5; i++ {
+		engine.Submit("status.writer", topicName, i)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	last, err := engine.LastEvent(topicName)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if last.Data.(int) != 4 {
+		t.Fatalf("expected last event data 4, got %d", last.Data.(int))
+	}
+
+	late := testActor{
+		name:  "late",
+		recvd: make([]eventActivity, 0),
+	}
+	engine.Register(Consumer{late.Id(), late.Accept})
+
+	if err := engine.SubscribeTo(topicName, late.Id()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Cached events are handed over by the dispatcher
+	time.Sleep(20 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if len(late.recvd) != 2 || late.recvd[0].data != 3 || late.recvd[1].data != 4 {
+		t.Fatalf("late subscriber expected cached events [3 4], got %v", late.recvd)
+	}
+}
+
+func TestDeduplication(t *testing.T) {
+
+	topicName := "idempotent"
+
+	engine := NewEngine()
+
+	if err := engine.CreateTopic(
+		NewTopic(topicName).
+			UsingBroadcast().
+			UsingDeduplication(200 * time.Millisecond)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	actor := testActor{
+		name:  "A",
+		recvd: make([]eventActivity, 0),
+	}
+	engine.Register(Consumer{actor.Id(), actor.Accept})
+
+	if err := engine.SubscribeTo(topicName, actor.Id()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	event := Event{
+		Spawned:  time.Now(),
+		Topic:    topicName,
+		Producer: "retrying.client",
+		Data:     1,
+		Id:       NewEventId(),
+	}
+
+	if err := engine.SubmitEvent(event); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.SubmitEvent(event); err != ErrEngineDuplicateEvent {
+		t.Fatalf("expected duplicate event error, got %v", err)
+	}
+
+	// Events without an id are never deduplicated
+	engine.Submit("retrying.client", topicName, 2)
+	engine.Submit("retrying.client", topicName, 2)
+
+	time.Sleep(300 * time.Millisecond)
+
+	// Outside of the window the id is accepted again
+	if err := engine.SubmitEvent(event); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if len(actor.recvd) != 4 {
+		t.Fatalf("expected 4 events to be delivered, got %d", len(actor.recvd))
+	}
+
+	if metrics := engine.Metrics(); metrics.Deduplicated != 1 || metrics.Submitted != 4 {
+		t.Fatalf("unexpected metrics: %+v", metrics)
+	}
+}
+
+func TestDeduplicationBeforeRateLimit(t *testing.T) {
+
+	topicName := "idempotent.limited"
+
+	engine := NewEngine()
+
+	if err := engine.CreateTopic(
+		NewTopic(topicName).
+			UsingDeduplication(time.Minute).
+			UsingRateLimit(RateLimit{
+				Rate:   0.001,
+				Burst:  2,
+				Policy: RateLimitReject,
+			})); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	event := Event{
+		Spawned:  time.Now(),
+		Topic:    topicName,
+		Producer: "retrying.client",
+		Id:       NewEventId(),
+	}
+
+	if err := engine.SubmitEvent(event); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Retries are dropped without spending the tokens left for new events
+	for i := 0; i < 3; i++ {
+		if err := engine.SubmitEvent(event); err != ErrEngineDuplicateEvent {
+			t.Fatalf("expected duplicate event error, got %v", err)
+		}
+	}
+
+	event.Id = NewEventId()
+	if err := engine.SubmitEvent(event); err != nil {
+		t.Fatalf("expected a token to be left, got %v", err)
+	}
+
+	// An event refused by the limit was never seen, so a
+	// retry is limited again rather than called a duplicate
+	event.Id = NewEventId()
+	for i := 0; i < 2; i++ {
+		if err := engine.SubmitEvent(event); err != ErrEngineRateLimited {
+			t.Fatalf("expected event to be rate limited, got %v", err)
+		}
+	}
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestRateLimiting(t *testing.T) {
+
+	limitedTopic := "limited"
+	openTopic := "open"
+
+	engine := NewEngine()
+
+	if err := engine.CreateTopic(
+		NewTopic(limitedTopic).
+			UsingBroadcast().
+			UsingRateLimit(RateLimit{
+				Rate:   1,
+				Burst:  2,
+				Policy: RateLimitDrop,
+			})); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	if err := engine.CreateTopic(NewTopic(openTopic)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	engine.SetProducerRateLimit("chatty", RateLimit{
+		Rate:   1,
+		Burst:  3,
+		Policy: RateLimitReject,
+	})
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := engine.Submit("chatty", openTopic, i); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	if err := engine.Submit("chatty", openTopic, 3); err != ErrEngineRateLimited {
+		t.Fatalf("expected producer to be rejected, got %v", err)
+	}
+
+	// Dropped events are silently discarded
+	for i := 0; i < 4; i++ {
+		if err := engine.Submit("quiet", limitedTopic, i); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	if metrics := engine.Metrics(); metrics.RateLimited != 3 {
+		t.Fatalf("expected 3 rate limited events, got %d", metrics.RateLimited)
+	}
+
+	engine.RemoveProducerRateLimit("chatty")
+
+	if err := engine.Submit("chatty", openTopic, 4); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestRateLimiterDelay(t *testing.T) {
+
+	limiter := NewRateLimiter(RateLimit{
+		Rate:   20,
+		Burst:  1,
+		Policy: RateLimitDelay,
+	})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if !limiter.Admit() {
+			t.Fatal("delay policy should always admit")
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Fatalf("expected submissions to be delayed, took %v", elapsed)
+	}
+}
+
+func TestRateLimitDelayHeldByEngine(t *testing.T) {
+
+	engine := NewEngine()
+
+	if err := engine.CreateTopic(
+		NewTopic("slow").
+			UsingRateLimit(RateLimit{
+				Rate:   10,
+				Burst:  1,
+				Policy: RateLimitDelay,
+			})); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	for _, topic := range []string{"feed", "other"} {
+		if err := engine.CreateTopic(NewTopic(topic)); err != nil {
+			t.Fatalf("err:%v", err)
+		}
+	}
+
+	if err := engine.AddForwardRule("feed", Forward("slow", "feed")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var mu sync.Mutex
+	slow := make([]int, 0)
+	var other time.Duration
+
+	start := time.Now()
+
+	engine.Register(Consumer{
+		Id: "slow.reader",
+		Fn: func(event *Event) {
+			mu.Lock()
+			slow = append(slow, event.Data.(int))
+			mu.Unlock()
+		},
+	})
+	engine.Register(Consumer{
+		Id: "other.reader",
+		Fn: func(event *Event) {
+			mu.Lock()
+			other = time.Since(start)
+			mu.Unlock()
+		},
+	})
+	engine.SubscribeTo("slow", "slow.reader")
+	engine.SubscribeTo("other", "other.reader")
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Neither the submitter nor the forwarding done by the
+	// dispatcher waits for the delayed events
+	for i := 0; i < 3; i++ {
+		engine.Submit("producer", "slow", i)
+	}
+	for i := 3; i < 6; i++ {
+		engine.Submit("producer", "feed", i)
+	}
+	engine.Submit("producer", "other", nil)
+
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("expected submission not to wait, took %v", elapsed)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	mu.Lock()
+	if other == 0 || other > 50*time.Millisecond {
+		t.Fatalf("expected other topics not to wait on delayed events, took %v", other)
+	}
+	if len(slow) >= 6 {
+		t.Fatalf("expected events to be delayed, got %v", slow)
+	}
+	mu.Unlock()
+
+	time.Sleep(600 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	expectSequence(t, "delayed", slow, []int{0, 1, 2, 3, 4, 5})
+}
+
+func TestRateLimitRefund(t *testing.T) {
+
+	engine := NewEngine()
+
+	if err := engine.CreateTopic(
+		NewTopic("limited").
+			UsingRateLimit(RateLimit{
+				Rate:   0.001,
+				Burst:  1,
+				Policy: RateLimitDrop,
+			})); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	if err := engine.CreateTopic(NewTopic("open")); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	engine.SetProducerRateLimit("chatty", RateLimit{
+		Rate:   0.001,
+		Burst:  2,
+		Policy: RateLimitReject,
+	})
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer engine.Stop()
+
+	for i := 0; i < 2; i++ {
+		if err := engine.Submit("chatty", "limited", i); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// The event dropped by the topic gave back the producer's token
+	if err := engine.Submit("chatty", "open", nil); err != nil {
+		t.Fatalf("expected a producer token to be left, got %v", err)
+	}
+
+	if err := engine.Submit("chatty", "open", nil); err != ErrEngineRateLimited {
+		t.Fatalf("expected producer to be rejected, got %v", err)
+	}
+}
+
+func TestQueueCapacity(t *testing.T) {
+
+	topicName := "backlogged"
+
+	engine := NewEngine().WithQueueCapacity(3)
+
+	if err := engine.CreateTopic(NewTopic(topicName)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	release := make(chan struct{})
+	var recvd atomic.Int32
+
+	engine.Register(Consumer{
+		Id: "stuck",
+		Fn: func(event *Event) {
+			<-release
+			recvd.Add(1)
+		},
+	})
+	engine.SubscribeTo(topicName, "stuck")
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	// The first event holds up the dispatcher while the rest wait
+	for i := 0; i < 4; i++ {
+		if err := engine.Submit("producer", topicName, i); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := engine.Submit("producer", topicName, 4); err != ErrEngineQueueFull {
+		t.Fatalf("expected a full queue to refuse the event, got %v", err)
+	}
+
+	close(release)
+
+	time.Sleep(20 * time.Millisecond)
+
+	if err := engine.Submit("producer", topicName, 5); err != nil {
+		t.Fatalf("expected the queue to have room again, got %v", err)
+	}
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if recvd.Load() != 5 {
+		t.Fatalf("expected 5 events to be delivered, got %d", recvd.Load())
+	}
+}
+
+func TestBatchConsumer(t *testing.T) {
+
+	topicName := "bulk"
+
+	engine := NewEngine()
+
+	if err := engine.CreateTopic(NewTopic(topicName)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	batchMu := new(sync.Mutex)
+	batches := make([][]*Event, 0)
+
+	engine.RegisterBatch(BatchConsumer{
+		Id: "bulk.writer",
+		Fn: func(events []*Event) {
+			batchMu.Lock()
+			defer batchMu.Unlock()
+			batches = append(batches, events)
+		},
+		MaxSize: 4,
+		MaxWait: 100 * time.Millisecond,
+	})
+
+	if err := engine.SubscribeTo(topicName, "bulk.writer"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// One full batch and a partial one flushed by time
+	for i := 0; i < 6; i++ {
+		engine.Submit("producer", topicName, i)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	// Partial batch flushed by stop
+	engine.Submit("producer", topicName, 6)
+
+	time.Sleep(20 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	batchMu.Lock()
+	defer batchMu.Unlock()
+
+	expected := []int{4, 2, 1}
+	if len(batches) != len(expected) {
+		t.Fatalf("expected %d batches, got %d", len(expected), len(batches))
+	}
+
+	next := 0
+	for i, batch := range batches {
+		if len(batch) != expected[i] {
+			t.Fatalf("batch %d expected size %d, got %d", i, expected[i], len(batch))
+		}
+		for _, event := range batch {
+			if event.Data.(int) != next {
+				t.Fatalf("batched events out of order")
+			}
+			next += 1
+		}
+	}
+}
+
+func TestBatchStaleTimer(t *testing.T) {
+
+	batches := make([][]*Event, 0)
+
+	b := newBatcher(NewEngine(), BatchConsumer{
+		Id: "bulk.writer",
+		Fn: func(events []*Event) {
+			batches = append(batches, events)
+		},
+		MaxSize: 2,
+		MaxWait: time.Minute,
+	})
+
+	b.add(&Event{Data: 0})
+	b.add(&Event{Data: 1})
+	b.add(&Event{Data: 2})
+
+	// The timer of the batch that filled up fires late
+	b.expire(0)
+
+	if len(batches) != 1 {
+		t.Fatalf("expected stale timer to leave the next batch be, got %d batches", len(batches))
+	}
+
+	b.expire(b.generation)
+
+	if len(batches) != 2 || len(batches[1]) != 1 {
+		t.Fatalf("expected current timer to flush the batch, got %d batches", len(batches))
+	}
+}
+
+func TestDeregister(t *testing.T) {
+
+	topicName := "bulk"
+
+	engine := NewEngine()
+
+	if err := engine.CreateTopic(NewTopic(topicName)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	var recvd atomic.Int32
+
+	engine.RegisterBatch(BatchConsumer{
+		Id: "bulk.writer",
+		Fn: func(events []*Event) {
+			recvd.Add(int32(len(events)))
+		},
+		MaxSize: 4,
+	})
+
+	if err := engine.SubscribeTo(topicName, "bulk.writer"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	engine.Submit("producer", topicName, 0)
+
+	time.Sleep(20 * time.Millisecond)
+
+	// What remains of the batch is handed off on deregistration
+	if err := engine.Deregister("bulk.writer"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Deregister("bulk.writer"); err != ErrEngineUnknownConsumer {
+		t.Fatalf("expected unknown consumer, got %v", err)
+	}
+
+	engine.Submit("producer", topicName, 1)
+
+	time.Sleep(20 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if recvd.Load() != 1 {
+		t.Fatalf("expected only the event before deregistration, got %d", recvd.Load())
+	}
+
+	if len(engine.batchers) != 0 {
+		t.Fatal("expected batcher to be removed")
+	}
+}
+
+func TestUpdateTopic(t *testing.T) {
+
+	topicName := "reshaped"
+
+	engine := NewEngine()
+
+	if err := engine.CreateTopic(NewTopic(topicName).UsingBroadcast()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var mu sync.Mutex
+	recvd := make(map[string]int)
+
+	for _, id := range []string{"left", "right"} {
+		engine.Register(Consumer{
+			Id: id,
+			Fn: func(event *Event) {
+				mu.Lock()
+				recvd[id] += 1
+				mu.Unlock()
+			},
+		})
+		if err := engine.SubscribeTo(topicName, id); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	engine.Submit("shaper", topicName, 0)
+	time.Sleep(50 * time.Millisecond)
+
+	if err := engine.UpdateTopic(
+		NewTopic(topicName).
+			UsingDirect().
+			UsingRoundRobinSelection()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	engine.Submit("shaper", topicName, 1)
+	engine.Submit("shaper", topicName, 2)
+	time.Sleep(50 * time.Millisecond)
+
+	if err := engine.UpdateTopic(NewTopic("missing")); err != ErrEngineUnknownTopic {
+		t.Fatalf("expected unknown topic, got %v", err)
+	}
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if recvd["left"] != 2 || recvd["right"] != 2 {
+		t.Fatalf("expected one broadcast and two direct events per consumer, got %v", recvd)
+	}
+}
+
+func TestDeleteTopicRecreate(t *testing.T) {
+
+	engine := NewEngine()
+
+	if err := engine.CreateTopic(NewTopic("scratch")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Removed at once, so the name is free straight away
+	engine.DeleteTopic("scratch")
+
+	if err := engine.CreateTopic(NewTopic("scratch")); err != nil {
+		t.Fatalf("expected deleted topic to be recreated, got %v", err)
+	}
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+func TestDeleteTopic(t *testing.T) {
+
+	engine := NewEngine()
+
+	for _, topic := range []string{"trigger", "drained", "rejected"} {
+		if err := engine.CreateTopic(NewTopic(topic)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	delivered := 0
+	deadLetters := 0
+	unsubscribed := make([]string, 0)
+	var refused error
+
+	engine.Register(Consumer{
+		Id: "sink",
+		Fn: func(event *Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			switch data := event.Data.(type) {
+			case *DeadLetter:
+				deadLetters += 1
+			case *ConsumerLifecycle:
+				if data.Change == ConsumerUnsubscribed {
+					unsubscribed = append(unsubscribed, data.Topic)
+				}
+			default:
+				delivered += 1
+			}
+		},
+	})
+
+	for _, topic := range []string{"drained", "rejected", TopicDeadLetter, TopicInternalConsumers} {
+		if err := engine.SubscribeTo(topic, "sink"); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	// Deleting from within a delivery leaves the events
+	// just submitted in flight behind the deletion
+	engine.Register(Consumer{
+		Id: "deleter",
+		Fn: func(event *Event) {
+			for i := 0; i < 3; i++ {
+				engine.Submit("deleter", "drained", i)
+				engine.Submit("deleter", "rejected", i)
+			}
+
+			engine.DeleteTopicWith("drained", DeleteDrain)
+			engine.DeleteTopicWith("rejected", DeleteReject)
+
+			mu.Lock()
+			refused = engine.Submit("deleter", "drained", 3)
+			mu.Unlock()
+		},
+	})
+
+	if err := engine.SubscribeTo("trigger", "deleter"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	engine.Submit("test", "trigger", nil)
+	time.Sleep(100 * time.Millisecond)
+
+	if err := engine.DeleteTopicWith("drained", DeleteDrain); err != ErrEngineUnknownTopic {
+		t.Fatalf("expected drained topic to be gone, got %v", err)
+	}
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if refused != ErrEngineTopicDeleting {
+		t.Fatalf("expected submission to draining topic to be refused, got %v", refused)
+	}
+
+	if delivered != 3 || deadLetters != 3 {
+		t.Fatalf("expected 3 drained and 3 rejected events, got %d and %d", delivered, deadLetters)
+	}
+
+	if len(unsubscribed) != 2 {
+		t.Fatalf("expected subscribers of both topics to be told, got %v", unsubscribed)
+	}
+}
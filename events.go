@@ -0,0 +1,139 @@
+package nerv
+
+const (
+	// Subtopics of nerv.internal that lifecycle changes are published on.
+	// Changes are published in the order they happen in
+	TopicInternalTopics    = "nerv.internal.topics"
+	TopicInternalConsumers = "nerv.internal.consumers"
+	TopicInternalModules   = "nerv.internal.modules"
+	TopicInternalEngine    = "nerv.internal.engine"
+)
+
+type TopicChange string
+
+const (
+	TopicCreated  TopicChange = "created"
+	TopicUpdated  TopicChange = "updated"
+	TopicDraining TopicChange = "draining"
+	TopicDeleted  TopicChange = "deleted"
+)
+
+// Data of the event published on nerv.internal.topics when a topic is
+// created, updated or deleted. Topics deleted with DeleteDrain are
+// draining until the events in flight for them have been delivered
+type TopicLifecycle struct {
+	Topic  string
+	Change TopicChange
+}
+
+type ConsumerChange string
+
+const (
+	ConsumerRegistered   ConsumerChange = "registered"
+	ConsumerDeregistered ConsumerChange = "deregistered"
+	ConsumerSubscribed   ConsumerChange = "subscribed"
+	ConsumerUnsubscribed ConsumerChange = "unsubscribed"
+)
+
+// Data of the event published on nerv.internal.consumers when a consumer
+// is registered, subscribed or unsubscribed. Topic is empty for changes
+// to the registration itself, and Group is set for group members
+type ConsumerLifecycle struct {
+	Consumer string
+	Topic    string
+	Group    string
+	Change   ConsumerChange
+}
+
+type ModuleChange string
+
+const (
+	ModuleStarted ModuleChange = "started"
+	ModuleFailed  ModuleChange = "failed"
+	ModuleStopped ModuleChange = "stopped"
+)
+
+// Data of the event published on nerv.internal.modules when a module is
+// started or shut down. Error is set when a module fails to start or
+// doesn't shut down in time
+type ModuleLifecycle struct {
+	Module string
+	Change ModuleChange
+	Error  string
+}
+
+type EngineState string
+
+const (
+	EngineStarting EngineState = "starting"
+	EngineRunning  EngineState = "running"
+	EngineStopping EngineState = "stopping"
+	EngineStopped  EngineState = "stopped"
+)
+
+// Data of the event published on nerv.internal.engine when the engine
+// changes state. Error is set when the engine stops because it failed
+// to start
+type EngineLifecycle struct {
+	Engine string
+	State  EngineState
+	Error  string
+}
+
+func (eng *Engine) createInternalTopics() {
+	for _, topic := range []string{
+		TopicInternal,
+		TopicDeadLetter,
+		TopicLoopDeadLetter,
+		TopicInternalTopics,
+		TopicInternalConsumers,
+		TopicInternalModules,
+		TopicInternalEngine,
+	} {
+		eng.CreateTopic(
+			NewTopic(topic).
+				UsingBroadcast().
+				UsingNoSelection())
+	}
+}
+
+func (eng *Engine) announceTopic(topic string, change TopicChange) {
+	eng.publishInternal(TopicInternalTopics, &TopicLifecycle{
+		Topic:  topic,
+		Change: change,
+	})
+}
+
+func (eng *Engine) announceConsumer(consumer string, topic string, change ConsumerChange) {
+	eng.publishInternal(TopicInternalConsumers, &ConsumerLifecycle{
+		Consumer: consumer,
+		Topic:    topic,
+		Change:   change,
+	})
+}
+
+func (eng *Engine) announceModule(module string, change ModuleChange, err error) {
+	lifecycle := &ModuleLifecycle{
+		Module: module,
+		Change: change,
+	}
+	if err != nil {
+		lifecycle.Error = err.Error()
+	}
+	eng.publishInternal(TopicInternalModules, lifecycle)
+}
+
+func (eng *Engine) engineLifecycle(state EngineState, err error) *EngineLifecycle {
+	lifecycle := &EngineLifecycle{
+		Engine: eng.Name(),
+		State:  state,
+	}
+	if err != nil {
+		lifecycle.Error = err.Error()
+	}
+	return lifecycle
+}
+
+func (eng *Engine) announceEngine(state EngineState, err error) {
+	eng.publishInternal(TopicInternalEngine, eng.engineLifecycle(state, err))
+}
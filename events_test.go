@@ -0,0 +1,146 @@
+package nerv
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLifecycleEvents(t *testing.T) {
+
+	var mu sync.Mutex
+	observed := make([]string, 0)
+
+	engine := NewEngine()
+
+	engine.Register(Consumer{
+		Id: "watcher",
+		Fn: func(event *Event) {
+			var entry string
+			switch change := event.Data.(type) {
+			case *TopicLifecycle:
+				entry = fmt.Sprintf("topic %s %s", change.Change, change.Topic)
+			case *ConsumerLifecycle:
+				entry = fmt.Sprintf("consumer %s %s %s", change.Change, change.Consumer, change.Topic)
+			case *ModuleLifecycle:
+				entry = fmt.Sprintf("module %s %s", change.Change, change.Module)
+			case *EngineLifecycle:
+				entry = fmt.Sprintf("engine %s %s", change.State, change.Engine)
+			default:
+				t.Errorf("unexpected lifecycle event on %s: %T", event.Topic, event.Data)
+				return
+			}
+			mu.Lock()
+			observed = append(observed, entry)
+			mu.Unlock()
+		},
+	})
+
+	// Changes made before the engine starts are delivered once it has
+	for _, topic := range []string{
+		TopicInternalTopics,
+		TopicInternalConsumers,
+		TopicInternalModules,
+		TopicInternalEngine,
+	} {
+		if err := engine.SubscribeTo(topic, "watcher"); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	engine.UseModule(&orderedModule{name: "journaled", journal: &moduleJournal{}}, []*TopicCfg{
+		NewTopic("journaled.out"),
+	})
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	engine.CreateTopic(NewTopic("scratch"))
+	engine.Register(Consumer{Id: "scratcher", Fn: func(event *Event) {}})
+	engine.SubscribeTo("scratch", "scratcher")
+	engine.Unsubscribe("scratch", "scratcher")
+	engine.DeleteTopicWith("scratch", DeleteDrain)
+
+	// Draining topics are removed once the dispatcher reaches them
+	for {
+		if _, _, err := engine.TopicOffsets("scratch"); err == ErrEngineUnknownTopic {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	expected := []string{
+		"topic created nerv.internal",
+		"topic created nerv.deadletter",
+		"topic created nerv.deadletter.loop",
+		"topic created nerv.internal.topics",
+		"topic created nerv.internal.consumers",
+		"topic created nerv.internal.modules",
+		"topic created nerv.internal.engine",
+		"consumer registered watcher ",
+		"consumer subscribed watcher nerv.internal.topics",
+		"consumer subscribed watcher nerv.internal.consumers",
+		"consumer subscribed watcher nerv.internal.modules",
+		"consumer subscribed watcher nerv.internal.engine",
+		"topic created journaled.out",
+		"engine starting nerv",
+		"module started journaled",
+		"engine running nerv",
+		"topic created scratch",
+		"consumer registered scratcher ",
+		"consumer subscribed scratcher scratch",
+		"consumer unsubscribed scratcher scratch",
+		"topic draining scratch",
+		"topic deleted scratch",
+		"engine stopping nerv",
+		"module stopped journaled",
+		"engine stopped nerv",
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if !reflect.DeepEqual(observed, expected) {
+		t.Fatalf("lifecycle events out of order\nexpected: %v\nobserved: %v", expected, observed)
+	}
+}
+
+func TestLifecycleEventsFailedStart(t *testing.T) {
+
+	engine := NewEngine()
+	engine.UseModule(&orderedModule{name: "broken", fail: true, journal: &moduleJournal{}}, []*TopicCfg{})
+
+	if err := engine.Start(); err == nil {
+		t.Fatal("expected engine to fail to start")
+	}
+
+	engine.queueMu.Lock()
+	defer engine.queueMu.Unlock()
+
+	states := make([]string, 0)
+	for _, item := range engine.queue {
+		switch change := item.event.Data.(type) {
+		case *ModuleLifecycle:
+			states = append(states, fmt.Sprintf("module %s %s", change.Change, change.Error))
+		case *EngineLifecycle:
+			states = append(states, fmt.Sprintf("engine %s %s", change.State, change.Error))
+		}
+	}
+
+	expected := []string{
+		"engine starting ",
+		"module failed " + errOrderedModule.Error(),
+		"engine stopped module broken: " + errOrderedModule.Error(),
+	}
+
+	if !reflect.DeepEqual(states, expected) {
+		t.Fatalf("expected %v, got %v", expected, states)
+	}
+}
@@ -6,7 +6,10 @@ replace github.com/bosley/nerv-go => ../../
 
 require (
 	github.com/bosley/nerv-go v0.0.0-00010101000000-000000000000
+	github.com/bosley/nerv-go/modules/modfsm v0.0.0-00010101000000-000000000000
 	github.com/bosley/nerv-go/modules/modhttp v0.0.0-00010101000000-000000000000
 )
 
 replace github.com/bosley/nerv-go/modules/modhttp => ../../modules/modhttp
+
+replace github.com/bosley/nerv-go/modules/modfsm => ../../modules/modfsm
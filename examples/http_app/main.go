@@ -1,10 +1,12 @@
 package main
 
 import (
+	_ "embed"
 	"errors"
 	"flag"
 	"fmt"
 	"github.com/bosley/nerv-go"
+	"github.com/bosley/nerv-go/modules/modfsm"
 	"github.com/bosley/nerv-go/modules/modhttp"
 	"log/slog"
 	"os"
@@ -43,6 +45,11 @@ type InternalMessage struct {
 
 var eventEngine *nerv.Engine
 
+// Topology used when no configuration file is given
+//
+//go:embed nerv.json
+var defaultTopology []byte
+
 func main() {
 	slog.SetDefault(
 		slog.New(
@@ -54,12 +61,14 @@ func main() {
 	addrPtr := flag.String("address", defaultAddress, "Address to bind for nerv server [address:port]")
 	sdtPtr := flag.Int("grace", defaultGracefulShutdownTimeSec, "Seconds to wait for shutdown of server; default: 5")
 	targetPtr := flag.String("rti", defaultProcFileName, "File to store runtime information of running server")
+	configPtr := flag.String("config", "", "Engine configuration file declaring topics, forwarding and modules; default: built-in")
 
 	pingPtr := flag.Bool("ping", false, "Ping server")
 	startPtr := flag.Bool("up", false, "Start server")
 	stopPtr := flag.Bool("down", false, "Stop server (graceful)")
 	cleanPtr := flag.Bool("clean", false, "Kills a server iff its running, and then wipes the rti file")
 	forcePtr := flag.Bool("force", false, "Force kills nerv instance when used with -down")
+	filterPtr := flag.Bool("filter", !defaultPermitUnknownProducers, "Only permit submissions from producers declared in the configuration")
 
 	topicPtr := flag.String("topic", appChannel, "Set topic for event")
 	tokenPtr := flag.String("token", "_UNUSED_", "Set token for http submissions")
@@ -130,7 +139,9 @@ func main() {
 	}
 
 	if *startPtr {
-		doHost(serverCfg, targetPtr)
+		topology := loadTopology(*configPtr)
+		topology.RestrictProducers = topology.RestrictProducers || *filterPtr
+		doHost(serverCfg, topology, targetPtr)
 		os.Exit(0)
 	}
 }
@@ -190,7 +201,25 @@ func doKillProc(file string, force bool) {
 	fmt.Println("success")
 }
 
-func doHost(cfg modhttp.Config, fileName *string) {
+func loadTopology(file string) *nerv.Config {
+
+	var topology *nerv.Config
+	var err error
+
+	if len(file) == 0 {
+		topology, err = nerv.ParseConfig(defaultTopology)
+	} else {
+		topology, err = nerv.LoadConfig(file)
+	}
+
+	if err != nil {
+		slog.Error("failed to load engine configuration", "file", file, "err", err.Error())
+		os.Exit(exitCodeErr)
+	}
+	return topology
+}
+
+func doHost(cfg modhttp.Config, topology *nerv.Config, fileName *string) {
 
 	if checkIfRunning(*fileName) {
 		slog.Error("server already running with configuration specified", "cfg file", *fileName)
@@ -201,7 +230,7 @@ func doHost(cfg modhttp.Config, fileName *string) {
 
 	wg := new(sync.WaitGroup)
 
-	LaunchServer(cfg, procInfo, wg)
+	LaunchServer(cfg, topology, procInfo, wg)
 
 	if err := WriteProcessInfo(*fileName, procInfo); err != nil {
 		slog.Error("failed to write process information", "err", err.Error())
@@ -216,21 +245,47 @@ func doHost(cfg modhttp.Config, fileName *string) {
 
 }
 
-func LaunchServer(cfg modhttp.Config, procInfo *ProcessInfo, wg *sync.WaitGroup) {
+func LaunchServer(cfg modhttp.Config, topology *nerv.Config, procInfo *ProcessInfo, wg *sync.WaitGroup) {
 
 	slog.Debug("LaunchServer", "address", cfg.Address, "pid", procInfo.PID)
 
-	eventEngine = nerv.NewEngine()
+	engine, err := nerv.NewEngineFromConfig(topology, nil)
+	if err != nil {
+		slog.Error("failed to build engine from configuration", "err", err.Error())
+		os.Exit(exitCodeErr)
+	}
+
+	eventEngine = engine.
+		WithSupervision(nerv.SupervisorCfg{
+			Interval:    time.Second,
+			Backoff:     time.Second,
+			MaxBackoff:  30 * time.Second,
+			MaxRestarts: 5,
+			Period:      time.Minute,
+		})
 
-	mod := modhttp.New(cfg, eventEngine)
+	if err := eventEngine.UseModule(
+		modhttp.New(cfg),
+		[]*nerv.TopicCfg{}); err != nil {
+		slog.Error("failed to use http module", "err", err.Error())
+		os.Exit(exitCodeErr)
+	}
 
-	topic := nerv.NewTopic("topic.http").
-		UsingBroadcast().
-		UsingArbitrary()
+	reaper := modfsm.New(appReaperId)
+
+	if err := reaper.Define(createReaperMachine()); err != nil {
+		slog.Error("failed to define reaper", "err", err.Error())
+		os.Exit(exitCodeErr)
+	}
+
+	if err := eventEngine.UseModule(
+		reaper,
+		reaper.Topics()); err != nil {
+		slog.Error("failed to use reaper", "err", err.Error())
+		os.Exit(exitCodeErr)
+	}
 
-	eventEngine.UseModule(
-		mod,
-		[]*nerv.TopicCfg{topic})
+	awaitSignal(wg)
 
 	StartEngine()
 
@@ -246,28 +301,6 @@ func LaunchServer(cfg modhttp.Config, procInfo *ProcessInfo, wg *sync.WaitGroup)
 	procInfo.Running = true
 
 	slog.Debug("confirmed server started")
-
-	if err := eventEngine.CreateTopic(
-		nerv.NewTopic(appChannel).
-			UsingBroadcast().
-			UsingNoSelection()); err != nil {
-
-		slog.Error("unable to create internal topic")
-		os.Exit(exitCodeErr)
-	}
-
-	eventEngine.Register(
-		nerv.Consumer{
-			Id: appReaperId,
-			Fn: createReaperFunction(procInfo, wg),
-		})
-
-	if err := eventEngine.SubscribeTo(
-		appChannel,
-		appReaperId); err != nil {
-		slog.Error("failed to register reaper", "err", err.Error())
-		os.Exit(exitCodeErr)
-	}
 }
 
 func StartEngine() {
@@ -304,7 +337,48 @@ func checkIfRunning(file string) bool {
 	return pi.Running
 }
 
-func createReaperFunction(procInfo *ProcessInfo, wg *sync.WaitGroup) nerv.EventRecvr {
+// The reaper serves until the first shutdown message arrives, then
+// drains while the count-down runs out and stops on its last tick
+func createReaperMachine() modfsm.Machine {
+
+	isShutdown := func(event *nerv.Event) bool {
+		msg, ok := event.Data.(*InternalMessage)
+		return ok && msg.Id == appMsgShutdown
+	}
+
+	isLastTick := func(event *nerv.Event) bool {
+		return isShutdown(event) && event.Data.(*InternalMessage).Data.(int) <= 1
+	}
+
+	return modfsm.Machine{
+		Name:    "reaper",
+		Initial: "serving",
+		States: []modfsm.State{
+			{Name: "serving"},
+			{
+				Name: "draining",
+				OnEntry: func(ctx *modfsm.ActionContext) {
+					slog.Warn(
+						"reaper received its own shutdown message",
+						"seconds_remaining", ctx.Trigger.Data.(*InternalMessage).Data.(int))
+				},
+			},
+			{
+				Name: "stopped",
+				OnEntry: func(ctx *modfsm.ActionContext) {
+					slog.Warn("reaper count-down complete")
+				},
+			},
+		},
+		Transitions: []modfsm.Transition{
+			{From: "serving", To: "draining", Topic: appChannel, When: isShutdown},
+			{From: "draining", To: "stopped", Topic: appChannel, When: isLastTick},
+			{From: "draining", To: "draining", Topic: appChannel, When: isShutdown},
+		},
+	}
+}
+
+func awaitSignal(wg *sync.WaitGroup) {
 
 	signalChannel := make(chan os.Signal, 2)
 	signal.Notify(signalChannel, os.Interrupt, syscall.SIGTERM)
@@ -324,17 +398,6 @@ func createReaperFunction(procInfo *ProcessInfo, wg *sync.WaitGroup) nerv.EventR
 			killServer()
 		}
 	}()
-
-	// Return a function that will be invoked when an event hits the internal
-	// channel. Reaper will give count-down to death by filtering all but its
-	// own kill signal
-	return func(event *nerv.Event) {
-		if event.Producer == appReaperId {
-			slog.Warn(
-				"reaper received its own shutdown message",
-				"seconds_remaining", event.Data.(*InternalMessage).Data.(int))
-		}
-	}
 }
 
 func killServer() {
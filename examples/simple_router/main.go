@@ -45,7 +45,13 @@ func main() {
 
   writers := make(map[string]nerv.Producer)
   for _, event := range events {
-    writers[event] = MustGet[nerv.Producer](engine.AddRoute(fmt.Sprintf("event.%s", event), func (c *nerv.Context) {
+    // Sensors can be noisy, so only take one alert per second per producer
+    topic := nerv.NewTopic(fmt.Sprintf("event.%s", event)).
+      UsingThrottle(time.Second, func(e *nerv.Event) string {
+        return e.Producer
+      })
+
+    writers[event] = MustGet[nerv.Producer](engine.AddTopicRoute(topic, func (c *nerv.Context) {
       fmt.Println("Received event [", event, "] [", c.Event.Data.(string), "] at", c.Event.Spawned)
     }))
   }
@@ -0,0 +1,151 @@
+package nerv
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrEngineInvalidGroup = errors.New("consumer group requires a name")
+
+// Consumers subscribed to a topic under a group name. Every group is handed
+// every event of the topic, but only one of its members is handed each
+// event, selected by the topic's selection method
+type consumerGroup struct {
+	name    string
+	members []*subscriber
+	rrIdx   int
+}
+
+// Subscribe a set of consumers to a topic as members of a group. Groups
+// receive events regardless of the topic's distribution, alongside the
+// consumers subscribed to the topic directly
+func (eng *Engine) SubscribeGroup(topicId string, group string, consumers ...string) error {
+
+	eng.log().Debug("SubscribeGroup", "topic", topicId, "group", group)
+
+	if len(group) == 0 {
+		return ErrEngineInvalidGroup
+	}
+
+	for _, s := range consumers {
+		if err := eng.joinGroup(topicId, group, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (eng *Engine) joinGroup(topicId string, group string, subId string) error {
+
+	eng.subMu.Lock()
+	defer eng.subMu.Unlock()
+
+	eng.topicMu.Lock()
+	defer eng.topicMu.Unlock()
+
+	subscribedFn, aok := eng.consumers[subId]
+	if !aok {
+		return ErrEngineUnknownConsumer
+	}
+
+	topic, tok := eng.topics[topicId]
+	if !tok {
+		return ErrEngineUnknownTopic
+	}
+
+	member := &subscriber{
+		id: subId,
+		fn: subscribedFn,
+	}
+
+	if g := topic.group(group); g != nil {
+		g.members = append(g.members, member)
+	} else {
+		topic.groups = append(topic.groups, &consumerGroup{
+			name:    group,
+			members: []*subscriber{member},
+		})
+	}
+
+	eng.announceMember(subId, topicId, group, ConsumerSubscribed)
+	return nil
+}
+
+func (eng *Engine) announceMember(consumer string, topic string, group string, change ConsumerChange) {
+	eng.publishInternal(TopicInternalConsumers, &ConsumerLifecycle{
+		Consumer: consumer,
+		Topic:    topic,
+		Group:    group,
+		Change:   change,
+	})
+}
+
+func (t *eventTopic) group(name string) *consumerGroup {
+	for _, g := range t.groups {
+		if g.name == name {
+			return g
+		}
+	}
+	return nil
+}
+
+// Remove a consumer from every group of the topic, retrieving the
+// names of the groups it was a member of. Groups left empty are removed
+func (t *eventTopic) leaveGroups(id string) []string {
+
+	left := make([]string, 0)
+	retainedGroups := t.groups[:0]
+
+	for _, g := range t.groups {
+		retained := make([]*subscriber, 0, len(g.members))
+		for _, member := range g.members {
+			if member.id != id {
+				retained = append(retained, member)
+			}
+		}
+		if len(retained) != len(g.members) {
+			left = append(left, g.name)
+		}
+		g.members = retained
+		if len(g.members) > 0 {
+			retainedGroups = append(retainedGroups, g)
+		}
+	}
+
+	clear(t.groups[len(retainedGroups):])
+	t.groups = retainedGroups
+	return left
+}
+
+// Select one available member of each group. Groups without an
+// available member have the event published on nerv.deadletter
+func (eng *Engine) selectGroups(event *Event, topic *eventTopic) []*subscriber {
+
+	recipients := make([]*subscriber, 0, len(topic.groups))
+
+	for _, g := range topic.groups {
+
+		var idx int
+		var err error
+
+		switch topic.selectionType {
+		case selectRoundRobin:
+			topic.rrMu.Lock()
+			idx, err = nextAvailable(g.members, &g.rrIdx, eng.available)
+			topic.rrMu.Unlock()
+		case selectRandom:
+			idx, err = randomAvailable(g.members, eng.available)
+		default:
+			idx, err = firstAvailable(g.members, eng.available)
+		}
+
+		if err != nil {
+			eng.log().Warn(err.Error(), "topic", event.Topic, "group", g.name)
+			eng.deadLetter(nil, event, fmt.Sprintf("group %s: %s", g.name, err.Error()))
+			continue
+		}
+
+		recipients = append(recipients, g.members[idx])
+	}
+	return recipients
+}
@@ -0,0 +1,146 @@
+package nerv
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConsumerGroups(t *testing.T) {
+
+	engine := NewEngine()
+
+	if err := engine.CreateTopic(
+		NewTopic("orders").
+			UsingBroadcast().
+			UsingRoundRobinSelection()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var mu sync.Mutex
+	recvd := make(map[string]int)
+
+	for _, id := range []string{"billing.0", "billing.1", "shipping.0", "shipping.1", "shipping.2", "auditor"} {
+		engine.Register(Consumer{
+			Id: id,
+			Fn: func(event *Event) {
+				mu.Lock()
+				recvd[id] += 1
+				mu.Unlock()
+			},
+		})
+	}
+
+	if err := engine.SubscribeGroup("orders", "billing", "billing.0", "billing.1"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.SubscribeGroup("orders", "shipping", "shipping.0", "shipping.1", "shipping.2"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.SubscribeTo("orders", "auditor"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.SubscribeGroup("orders", "", "auditor"); err != ErrEngineInvalidGroup {
+		t.Fatalf("expected unnamed group to be refused, got %v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 6; i++ {
+		engine.Submit("shop", "orders", i)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	for id, expected := range map[string]int{
+		"billing.0":  3,
+		"billing.1":  3,
+		"shipping.0": 2,
+		"shipping.1": 2,
+		"shipping.2": 2,
+		"auditor":    6,
+	} {
+		if recvd[id] != expected {
+			t.Fatalf("expected %s to receive %d events, got %d", id, expected, recvd[id])
+		}
+	}
+	mu.Unlock()
+
+	// Remaining members take over the events of those that leave
+	if err := engine.Unsubscribe("orders", "billing.1"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Unsubscribe("orders", "billing.1"); err != ErrEngineUnknownConsumer {
+		t.Fatalf("expected departed member to be unknown, got %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		engine.Submit("shop", "orders", i)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if recvd["billing.0"] != 5 || recvd["billing.1"] != 3 {
+		t.Fatalf("expected remaining billing member to receive every event, got %v", recvd)
+	}
+}
+
+func TestConsumerGroupsDirect(t *testing.T) {
+
+	engine := NewEngine()
+
+	if err := engine.CreateTopic(NewTopic("jobs").UsingDirect()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var mu sync.Mutex
+	recvd := make(map[string]int)
+
+	for _, id := range []string{"worker.a", "worker.b", "indexer"} {
+		engine.Register(Consumer{
+			Id: id,
+			Fn: func(event *Event) {
+				mu.Lock()
+				recvd[id] += 1
+				mu.Unlock()
+			},
+		})
+	}
+
+	// A direct topic hands each event to one of its subscribers,
+	// while every group still receives each event as well
+	engine.SubscribeTo("jobs", "worker.a", "worker.b")
+	engine.SubscribeGroup("jobs", "indexers", "indexer")
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 4; i++ {
+		engine.Submit("scheduler", "jobs", i)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if recvd["worker.a"]+recvd["worker.b"] != 4 || recvd["indexer"] != 4 {
+		t.Fatalf("expected workers to share and indexer to receive every event, got %v", recvd)
+	}
+}
@@ -0,0 +1,224 @@
+package nerv
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	// Topic that events dropped for exceeding the hop limit are published on
+	TopicLoopDeadLetter = "nerv.deadletter.loop"
+
+	defaultMaxHops = 32
+)
+
+var ErrEngineHopLimit = errors.New("event exceeded hop limit")
+
+// Data of the warning published on nerv.internal when an event is
+// dropped for having been resubmitted more times than permitted
+type RoutingLoop struct {
+	Topic    string
+	Producer string
+	Hops     int
+	Trail    []string
+}
+
+// Limit how many times an event may be resubmitted in response to
+// the events before it. Events over the limit are dropped onto
+// nerv.deadletter.loop rather than delivered
+func (eng *Engine) WithMaxHops(hops int) *Engine {
+	eng.maxHops = hops
+	return eng
+}
+
+// Submit an event in response to another, such as from within the consumer
+// the other was delivered to. The event carries on the hop count and the
+// trail of topics of the one it responds to, so that the engine can tell
+// when events are cycling between topics. Delivered events that are
+// submitted again as they are count as a hop without SubmitFrom
+func (eng *Engine) SubmitFrom(parent *Event, event Event) error {
+	return eng.SubmitEvent(resubmission(parent, event))
+}
+
+// A path events take from one topic to others, declared
+// by whatever resubmits them, such as a stream or join
+type declaredRoute struct {
+	by   string
+	from []string
+	to   []string
+}
+
+func (eng *Engine) declareRoute(by string, from []string, to ...string) {
+	eng.routeMu.Lock()
+	defer eng.routeMu.Unlock()
+
+	eng.routes = append(eng.routes, declaredRoute{
+		by:   by,
+		from: append([]string{}, from...),
+		to:   slices.DeleteFunc(append([]string{}, to...), func(topic string) bool { return len(topic) == 0 }),
+	})
+}
+
+// Check the declared topology, being the forwarding rules, aliases,
+// streams and joins, for a path that leads from a topic back onto
+// itself. Such a cycle is reported with the topics along it. Consumers
+// that resubmit events are not declared, and are left to the hop limit
+func (eng *Engine) CheckTopology() error {
+
+	eng.topicMu.Lock()
+	topics := make([]string, 0, len(eng.topics))
+	for name := range eng.topics {
+		topics = append(topics, name)
+	}
+	eng.topicMu.Unlock()
+
+	eng.routeMu.RLock()
+	defer eng.routeMu.RUnlock()
+
+	routes := append([]declaredRoute{}, eng.routes...)
+	for _, existing := range eng.rules {
+		routes = append(routes, declaredRoute{
+			by:   existing.id,
+			from: existing.rule.From,
+			to:   []string{existing.rule.To},
+		})
+	}
+
+	for _, route := range routes {
+		for _, topic := range append(append([]string{}, route.from...), route.to...) {
+			if !strings.Contains(topic, TopicWildcardSegment) {
+				topics = append(topics, eng.resolveAlias(topic))
+			}
+		}
+	}
+
+	slices.Sort(topics)
+	topics = slices.Compact(topics)
+
+	next := make(map[string][]string)
+	for _, topic := range topics {
+		for _, route := range routes {
+			for _, from := range route.from {
+				if MatchTopic(from, topic) || eng.resolveAlias(from) == topic {
+					for _, to := range route.to {
+						next[topic] = append(next[topic], eng.resolveAlias(to))
+					}
+					break
+				}
+			}
+		}
+	}
+
+	if cycle := findCycle(topics, next); len(cycle) > 0 {
+		return fmt.Errorf("%w: %s", ErrEngineRoutingLoop, strings.Join(cycle, " -> "))
+	}
+	return nil
+}
+
+// Retrieve the topics along the first cycle found in the graph,
+// starting and ending with the same topic, if there is one
+func findCycle(topics []string, next map[string][]string) []string {
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[string]int)
+	path := make([]string, 0)
+
+	var visit func(topic string) []string
+	visit = func(topic string) []string {
+		switch state[topic] {
+		case visiting:
+			start := slices.Index(path, topic)
+			return append(slices.Clone(path[start:]), topic)
+		case visited:
+			return nil
+		}
+
+		state[topic] = visiting
+		path = append(path, topic)
+
+		for _, to := range next[topic] {
+			if cycle := visit(to); cycle != nil {
+				return cycle
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[topic] = visited
+		return nil
+	}
+
+	for _, topic := range topics {
+		if cycle := visit(topic); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+func resubmission(parent *Event, event Event) Event {
+	event.Hops = parent.Hops + 1
+	event.Trail = append(slices.Clip(parent.Trail), parent.Topic)
+	event.via = ""
+	return event
+}
+
+// Mark an event as having been delivered on its topic
+func (event *Event) delivered() {
+	event.via = event.Topic
+}
+
+// Count a delivered event that is submitted again, however
+// it was resubmitted, as a hop from the topic it was delivered on
+func carryHop(event *Event) {
+	if len(event.via) == 0 {
+		return
+	}
+	event.Hops += 1
+	event.Trail = append(slices.Clip(event.Trail), event.via)
+	event.via = ""
+}
+
+// Retrieve the event with the most hops, such that an event derived from
+// several others is held to the longest path that led to it
+func furthest(events []*Event) *Event {
+	var selected *Event
+	for _, event := range events {
+		if selected == nil || event.Hops > selected.Hops {
+			selected = event
+		}
+	}
+	return selected
+}
+
+// Drop an event that has been resubmitted too many times, warning on
+// nerv.internal. Reports whether the event was dropped
+func (eng *Engine) dropLooping(event *Event) bool {
+
+	if eng.maxHops <= 0 || event.Hops <= eng.maxHops {
+		return false
+	}
+
+	eng.log().Warn("dropping event over hop limit", "topic", event.Topic, "producer", event.Producer, "hops", event.Hops, "trail", event.Trail)
+
+	dropped := *event
+
+	eng.publishInternal(TopicLoopDeadLetter, &DeadLetter{
+		Reason: fmt.Sprintf("%s: %d hops", ErrEngineHopLimit.Error(), event.Hops),
+		Event:  &dropped,
+	})
+
+	eng.publishInternal(TopicInternal, &RoutingLoop{
+		Topic:    event.Topic,
+		Producer: event.Producer,
+		Hops:     event.Hops,
+		Trail:    event.Trail,
+	})
+	return true
+}
@@ -0,0 +1,238 @@
+package nerv
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHopLimit(t *testing.T) {
+
+	engine := NewEngine().WithMaxHops(5)
+
+	for _, topic := range []string{"ping", "pong"} {
+		if err := engine.CreateTopic(NewTopic(topic)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	delivered := 0
+	dropped := make([]*DeadLetter, 0)
+	warnings := make([]*RoutingLoop, 0)
+
+	// Each side answers the other, which would never end
+	// were the events not dropped once over the limit
+	for _, pair := range [][2]string{{"ping", "pong"}, {"pong", "ping"}} {
+		from, to := pair[0], pair[1]
+		engine.Register(Consumer{
+			Id: "echo." + from,
+			Fn: func(event *Event) {
+				mu.Lock()
+				delivered++
+				mu.Unlock()
+				engine.SubmitFrom(event, Event{Spawned: time.Now(), Topic: to, Producer: "echo." + from})
+			},
+		})
+		engine.SubscribeTo(from, "echo."+from)
+	}
+
+	engine.Register(Consumer{
+		Id: "recorder",
+		Fn: func(event *Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			switch data := event.Data.(type) {
+			case *DeadLetter:
+				dropped = append(dropped, data)
+			case *RoutingLoop:
+				warnings = append(warnings, data)
+			}
+		},
+	})
+	engine.SubscribeTo(TopicLoopDeadLetter, "recorder")
+	engine.SubscribeTo(TopicInternal, "recorder")
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	engine.Submit("client", "ping", nil)
+
+	time.Sleep(50 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if delivered != 6 {
+		t.Fatalf("expected events up to the hop limit to be delivered, got %d", delivered)
+	}
+
+	if len(dropped) != 1 || len(warnings) != 1 {
+		t.Fatalf("expected a single dropped event and warning, got %d and %d", len(dropped), len(warnings))
+	}
+
+	event := dropped[0].Event
+	if event.Topic != "ping" || event.Hops != 6 {
+		t.Fatalf("expected the event over the limit to be dropped, got %s with %d hops", event.Topic, event.Hops)
+	}
+
+	expected := []string{"ping", "pong", "ping", "pong", "ping", "pong"}
+	if !slices.Equal(event.Trail, expected) {
+		t.Fatalf("expected trail %v, got %v", expected, event.Trail)
+	}
+
+	if warnings[0].Producer != "echo.pong" || !slices.Equal(warnings[0].Trail, expected) {
+		t.Fatalf("unexpected warning %+v", warnings[0])
+	}
+}
+
+func TestHopLimitWithoutSubmitFrom(t *testing.T) {
+
+	engine := NewEngine().WithMaxHops(5)
+
+	for _, topic := range []string{"ping", "pong"} {
+		if err := engine.CreateTopic(NewTopic(topic)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	mod := &paneModule{}
+	if err := engine.UseModule(mod, []*TopicCfg{}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	var mu sync.Mutex
+	delivered := 0
+	dropped := make([]*DeadLetter, 0)
+
+	// The delivered event itself is passed on, by one side through
+	// the engine and by the other through a module's pane
+	engine.Register(Consumer{
+		Id: "echo.ping",
+		Fn: func(event *Event) {
+			mu.Lock()
+			delivered++
+			mu.Unlock()
+			next := *event
+			next.Topic = "pong"
+			engine.SubmitEvent(next)
+		},
+	})
+	engine.SubscribeTo("ping", "echo.ping")
+
+	mod.pane.SubscribeTo("pong", []Consumer{{
+		Id: "echo.pong",
+		Fn: func(event *Event) {
+			mu.Lock()
+			delivered++
+			mu.Unlock()
+			event.Topic = "ping"
+			mod.pane.SubmitEvent(event)
+		},
+	}}, true)
+
+	engine.Register(Consumer{
+		Id: "recorder",
+		Fn: func(event *Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			dropped = append(dropped, event.Data.(*DeadLetter))
+		},
+	})
+	engine.SubscribeTo(TopicLoopDeadLetter, "recorder")
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	engine.Submit("client", "ping", nil)
+
+	time.Sleep(50 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if delivered != 6 || len(dropped) != 1 {
+		t.Fatalf("expected events up to the hop limit to be delivered, got %d and %d dropped", delivered, len(dropped))
+	}
+
+	expected := []string{"ping", "pong", "ping", "pong", "ping", "pong"}
+	if event := dropped[0].Event; event.Hops != 6 || !slices.Equal(event.Trail, expected) {
+		t.Fatalf("expected trail %v, got %v with %d hops", expected, event.Trail, event.Hops)
+	}
+}
+
+func TestMaxHopsConfig(t *testing.T) {
+
+	cfg, err := ParseConfig([]byte(`{"max_hops": 3}`))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	engine, err := NewEngineFromConfig(cfg, nil)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if engine.maxHops != 3 {
+		t.Fatalf("expected configured hop limit, got %d", engine.maxHops)
+	}
+
+	if NewEngine().maxHops != defaultMaxHops {
+		t.Fatal("expected default hop limit")
+	}
+}
+
+func TestCheckTopology(t *testing.T) {
+
+	engine := NewEngine()
+
+	for _, topic := range []string{"readings", "alerts", "acks"} {
+		if err := engine.CreateTopic(NewTopic(topic)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	if err := From(engine, "readings").To("smoothed"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.AddForwardRule("smoothed", Forward("alerts", "smoothed")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.CheckTopology(); err != nil {
+		t.Fatalf("expected no cycle, got %v", err)
+	}
+
+	// Acknowledged alerts are fed back in as readings
+	err := Join(engine, JoinCfg{
+		Topics: []string{"alerts", "acks"},
+		Key:    func(event *Event) string { return event.Id },
+		Output: "readings",
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	err = engine.CheckTopology()
+	if !errors.Is(err, ErrEngineRoutingLoop) {
+		t.Fatalf("expected routing loop, got %v", err)
+	}
+
+	if !strings.HasSuffix(err.Error(), "readings -> smoothed -> alerts -> readings") {
+		t.Fatalf("expected cycle to be reported, got %v", err)
+	}
+}
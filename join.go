@@ -0,0 +1,164 @@
+package nerv
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrJoinInvalid = errors.New("join requires two or more topics, a key function and an output topic")
+
+var joinCount atomic.Uint64
+
+// Configuration of a join across topics. Events from each of the topics
+// that share a key are buffered until one has arrived from every topic,
+// at which point they are published together on Output. If Window passes
+// before that happens the partial set is published on Timeout, if given
+type JoinCfg struct {
+	Topics  []string
+	Key     func(event *Event) string
+	Window  time.Duration
+	Output  string
+	Timeout string
+}
+
+// Data of the events published by a join. Events are
+// indexed by the topic that they were submitted to
+type JoinedEvents struct {
+	Key    string
+	Events map[string]*Event
+}
+
+type pendingJoin struct {
+	events map[string]*Event
+	timer  *time.Timer
+}
+
+type joiner struct {
+	eng     *Engine
+	cfg     JoinCfg
+	id      string
+	pending map[string]*pendingJoin
+	mu      sync.Mutex
+}
+
+// Correlate events across topics by key, publishing them together once
+// every topic has contributed an event for that key
+func Join(eng *Engine, cfg JoinCfg) error {
+
+	if len(cfg.Topics) < 2 || cfg.Key == nil || len(cfg.Output) == 0 {
+		return ErrJoinInvalid
+	}
+
+	j := &joiner{
+		eng:     eng,
+		cfg:     cfg,
+		id:      fmt.Sprintf("join:%d:%s", joinCount.Add(1), cfg.Output),
+		pending: make(map[string]*pendingJoin),
+	}
+
+	for _, topic := range []string{cfg.Output, cfg.Timeout} {
+		if len(topic) == 0 {
+			continue
+		}
+		if err := eng.CreateTopic(NewTopic(topic)); err != nil && !errors.Is(err, ErrEngineDuplicateTopic) {
+			return err
+		}
+	}
+
+	j.eng.log().Debug("join", "id", j.id, "topics", cfg.Topics, "output", cfg.Output)
+
+	eng.declareRoute(j.id, cfg.Topics, cfg.Output, cfg.Timeout)
+
+	eng.Register(Consumer{
+		Id: j.id,
+		Fn: j.add,
+	})
+
+	for _, topic := range cfg.Topics {
+		if err := eng.SubscribeTo(topic, j.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *joiner) add(event *Event) {
+
+	key := j.cfg.Key(event)
+	if len(key) == 0 {
+		return
+	}
+
+	j.mu.Lock()
+
+	pending, ok := j.pending[key]
+	if !ok {
+		pending = &pendingJoin{
+			events: make(map[string]*Event),
+		}
+		if j.cfg.Window > 0 {
+			pending.timer = time.AfterFunc(j.cfg.Window, func() {
+				j.expire(key, pending)
+			})
+		}
+		j.pending[key] = pending
+	}
+
+	pending.events[event.Topic] = event
+
+	if len(pending.events) < len(j.cfg.Topics) {
+		j.mu.Unlock()
+		return
+	}
+
+	if pending.timer != nil {
+		pending.timer.Stop()
+	}
+	delete(j.pending, key)
+	j.mu.Unlock()
+
+	j.publish(j.cfg.Output, key, pending)
+}
+
+func (j *joiner) expire(key string, pending *pendingJoin) {
+
+	j.mu.Lock()
+	if j.pending[key] != pending {
+		j.mu.Unlock()
+		return
+	}
+	delete(j.pending, key)
+	j.mu.Unlock()
+
+	j.eng.log().Debug("join expired", "id", j.id, "key", key, "received", len(pending.events))
+
+	if len(j.cfg.Timeout) > 0 {
+		j.publish(j.cfg.Timeout, key, pending)
+	}
+}
+
+// Publish the joined events in response to whichever of them
+// travelled furthest, so that joins count towards hop limits. Joins
+// are declared on the engine, so aren't held to producer permissions
+func (j *joiner) publish(topic string, key string, pending *pendingJoin) {
+
+	events := make([]*Event, 0, len(pending.events))
+	for _, event := range pending.events {
+		events = append(events, event)
+	}
+
+	if err := j.eng.submitEvent(resubmission(furthest(events), Event{
+		Spawned:  time.Now(),
+		Topic:    topic,
+		Producer: j.id,
+		Data: &JoinedEvents{
+			Key:    key,
+			Events: pending.events,
+		},
+	}), false); err != nil {
+		j.eng.log().Debug("join failed to submit", "id", j.id, "topic", topic, "err", err.Error())
+	}
+}
@@ -0,0 +1,94 @@
+package nerv
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type joinRecvr struct {
+	mu     sync.Mutex
+	joined []*JoinedEvents
+}
+
+func (r *joinRecvr) Accept(event *Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.joined = append(r.joined, event.Data.(*JoinedEvents))
+}
+
+func (r *joinRecvr) get() []*JoinedEvents {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*JoinedEvents{}, r.joined...)
+}
+
+type orderEvent struct {
+	OrderId string
+}
+
+func TestJoin(t *testing.T) {
+
+	engine := NewEngine()
+
+	for _, topic := range []string{"orders", "payments"} {
+		if err := engine.CreateTopic(NewTopic(topic)); err != nil {
+			t.Fatalf("err:%v", err)
+		}
+	}
+
+	if err := Join(engine, JoinCfg{Topics: []string{"orders"}}); err != ErrJoinInvalid {
+		t.Fatalf("expected invalid join, got %v", err)
+	}
+
+	if err := Join(engine, JoinCfg{
+		Topics: []string{"orders", "payments"},
+		Key: func(event *Event) string {
+			return event.Data.(*orderEvent).OrderId
+		},
+		Window:  100 * time.Millisecond,
+		Output:  "orders.paid",
+		Timeout: "orders.unpaid",
+	}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	paid := &joinRecvr{}
+	unpaid := &joinRecvr{}
+
+	engine.Register(Consumer{"paid", paid.Accept})
+	engine.Register(Consumer{"unpaid", unpaid.Accept})
+
+	if err := engine.SubscribeTo("orders.paid", "paid"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := engine.SubscribeTo("orders.unpaid", "unpaid"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	engine.Submit("shop", "orders", &orderEvent{"a"})
+	engine.Submit("shop", "orders", &orderEvent{"b"})
+	engine.Submit("bank", "payments", &orderEvent{"a"})
+
+	time.Sleep(200 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if joined := paid.get(); len(joined) != 1 || joined[0].Key != "a" || len(joined[0].Events) != 2 {
+		t.Fatalf("expected order a to be joined with its payment, got %v", joined)
+	}
+
+	expired := unpaid.get()
+	if len(expired) != 1 || expired[0].Key != "b" {
+		t.Fatalf("expected order b to time out, got %v", expired)
+	}
+	if _, ok := expired[0].Events["orders"]; !ok {
+		t.Fatal("expired join is missing the order event")
+	}
+}
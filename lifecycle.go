@@ -0,0 +1,317 @@
+package nerv
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	defaultModuleShutdownTimeout = 5 * time.Second
+)
+
+var ErrEngineModuleCycle = errors.New("module dependency cycle")
+var ErrEngineModuleTimeout = errors.New("module shutdown timed out")
+var ErrEngineModuleRequired = errors.New("module required by a started module")
+
+// Limit how long each module may take to shut down before the
+// engine gives up on it and continues shutting down the rest.
+// Modules implementing ShutdownTimed set their own limit
+func (eng *Engine) WithModuleShutdownTimeout(timeout time.Duration) *Engine {
+	eng.shutdownTimeout = timeout
+	return eng
+}
+
+func (eng *Engine) shutdownTimeoutOf(mod Module) time.Duration {
+	if timed, ok := mod.(ShutdownTimed); ok {
+		if timeout := timed.ShutdownTimeout(); timeout > 0 {
+			return timeout
+		}
+	}
+	return eng.shutdownTimeout
+}
+
+// Order the modules such that every module comes after those it depends
+// on. Modules with no ordering between them keep the order they were
+// handed to the engine in. Expects eng.modMu to be held
+func (eng *Engine) moduleOrder() ([]string, error) {
+
+	for _, name := range eng.modOrder {
+		for _, dep := range moduleDependencies(eng.mmp[name].module) {
+			if _, ok := eng.mmp[dep]; !ok {
+				return nil, fmt.Errorf("%w: %s depends on %s", ErrEngineUnknownModule, name, dep)
+			}
+		}
+	}
+
+	placed := make(map[string]bool)
+	order := make([]string, 0, len(eng.modOrder))
+
+	for len(order) < len(eng.modOrder) {
+		progressed := false
+		for _, name := range eng.modOrder {
+			if placed[name] {
+				continue
+			}
+			ready := true
+			for _, dep := range moduleDependencies(eng.mmp[name].module) {
+				if !placed[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				placed[name] = true
+				order = append(order, name)
+				progressed = true
+				break
+			}
+		}
+		if !progressed {
+			unplaced := make([]string, 0)
+			for _, name := range eng.modOrder {
+				if !placed[name] {
+					unplaced = append(unplaced, name)
+				}
+			}
+			return nil, fmt.Errorf("%w between %v", ErrEngineModuleCycle, unplaced)
+		}
+	}
+	return order, nil
+}
+
+func moduleDependencies(mod Module) []string {
+	if dependent, ok := mod.(ModuleDependent); ok {
+		return dependent.Dependencies()
+	}
+	return nil
+}
+
+// Start the modules in dependency order. Should one fail, those that
+// were already started are shut down again in reverse order and the
+// failure is returned along with any that occurred while rolling back
+func (eng *Engine) startModules() error {
+
+	eng.lifeMu.Lock()
+	defer eng.lifeMu.Unlock()
+
+	eng.modMu.Lock()
+	order, err := eng.moduleOrder()
+	modules := make([]Module, len(order))
+	for i, name := range order {
+		modules[i] = eng.mmp[name].module
+	}
+	eng.modMu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	started := make([]Module, 0, len(modules))
+
+	for _, mod := range modules {
+		eng.log().Debug("indicating start to module", "module", mod.GetName())
+		if err := eng.invokeStart(mod); err != nil {
+			eng.log().Error("module failed to start", "module", mod.GetName(), "err", err.Error())
+			return errors.Join(
+				fmt.Errorf("module %s: %w", mod.GetName(), err),
+				eng.shutdownModules(started))
+		}
+		started = append(started, mod)
+	}
+
+	eng.modMu.Lock()
+	eng.started = started
+	eng.modMu.Unlock()
+	return nil
+}
+
+// Shut down the given modules in the reverse of the order they were
+// started in, waiting at most the shutdown timeout on each of them
+func (eng *Engine) shutdownModules(started []Module) error {
+
+	errs := make([]error, 0)
+
+	for i := len(started) - 1; i >= 0; i-- {
+		mod := started[i]
+		eng.log().Debug("indicating shutdown to module", "module", mod.GetName())
+		if err := eng.shutdownModule(mod); err != nil {
+			eng.log().Warn("module did not shut down in time", "module", mod.GetName(), "timeout", eng.shutdownTimeoutOf(mod))
+			errs = append(errs, fmt.Errorf("module %s: %w", mod.GetName(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Start a module handed to an already running engine. The modules it
+// depends on must already be started. Should it fail to start, it is
+// removed along with the topics that were created for it
+func (eng *Engine) startModule(mod Module) error {
+
+	eng.lifeMu.Lock()
+	defer eng.lifeMu.Unlock()
+
+	name := mod.GetName()
+
+	eng.modMu.Lock()
+	var err error
+	for _, dep := range moduleDependencies(mod) {
+		if !eng.isStarted(dep) {
+			err = fmt.Errorf("%w: %s depends on %s", ErrEngineUnknownModule, name, dep)
+			break
+		}
+	}
+	eng.modMu.Unlock()
+
+	if err == nil {
+		eng.log().Debug("indicating start to module", "module", name)
+		err = eng.invokeStart(mod)
+	}
+
+	if err != nil {
+		eng.log().Error("module failed to start", "module", name, "err", err.Error())
+		eng.detachModule(name, true)
+		return fmt.Errorf("module %s: %w", name, err)
+	}
+
+	eng.modMu.Lock()
+	eng.started = append(eng.started, mod)
+	eng.modMu.Unlock()
+	return nil
+}
+
+// Shut down a module and remove it from the engine along with every
+// subscription it made through its pane. Consumers it registered through
+// its pane are deregistered, and if deleteTopics is set, the topics that
+// were created for it are deleted
+func (eng *Engine) RemoveModule(name string, deleteTopics bool) error {
+
+	eng.lifeMu.Lock()
+	defer eng.lifeMu.Unlock()
+
+	eng.modMu.Lock()
+
+	entry, ok := eng.mmp[name]
+	if !ok {
+		eng.modMu.Unlock()
+		return ErrEngineUnknownModule
+	}
+
+	for _, other := range eng.started {
+		for _, dep := range moduleDependencies(other) {
+			if dep == name {
+				eng.modMu.Unlock()
+				return fmt.Errorf("%w: %s depends on %s", ErrEngineModuleRequired, other.GetName(), name)
+			}
+		}
+	}
+
+	started := eng.isStarted(name)
+	eng.modMu.Unlock()
+
+	var err error
+	if started {
+		eng.log().Debug("indicating shutdown to module", "module", name)
+		if err = eng.shutdownModule(entry.module); err != nil {
+			eng.log().Warn("module did not shut down in time", "module", name, "timeout", eng.shutdownTimeoutOf(entry.module))
+			err = fmt.Errorf("module %s: %w", name, err)
+		}
+	}
+
+	eng.detachModule(name, deleteTopics)
+	return err
+}
+
+// Forget a module, undoing what was done on its behalf
+func (eng *Engine) detachModule(name string, deleteTopics bool) {
+
+	eng.modMu.Lock()
+
+	entry := eng.mmp[name]
+	delete(eng.mmp, name)
+
+	for i, other := range eng.modOrder {
+		if other == name {
+			eng.modOrder = append(eng.modOrder[:i], eng.modOrder[i+1:]...)
+			break
+		}
+	}
+
+	for i, other := range eng.started {
+		if other.GetName() == name {
+			eng.started = append(eng.started[:i], eng.started[i+1:]...)
+			break
+		}
+	}
+
+	for timer := range entry.scheduled {
+		timer.Stop()
+	}
+	entry.scheduled = nil
+
+	subscriptions := entry.subscriptions
+	topics := entry.topics
+
+	eng.modMu.Unlock()
+
+	for _, sub := range subscriptions {
+		if err := eng.Unsubscribe(sub.topic, sub.consumer); err != nil {
+			eng.log().Debug("module subscription already gone", "module", name, "topic", sub.topic, "consumer", sub.consumer)
+		}
+		if sub.registered {
+			eng.deregister(sub.consumer)
+		}
+	}
+
+	if deleteTopics {
+		for _, topic := range topics {
+			if err := eng.DeleteTopicWith(topic, DeleteReject); err != nil {
+				eng.log().Debug("module topic already gone", "module", name, "topic", topic)
+			}
+		}
+	}
+}
+
+// Expects eng.modMu to be held
+func (eng *Engine) isStarted(name string) bool {
+	for _, mod := range eng.started {
+		if mod.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Start a module, announcing the outcome on nerv.internal.modules
+func (eng *Engine) invokeStart(mod Module) error {
+	if err := mod.Start(); err != nil {
+		eng.announceModule(mod.GetName(), ModuleFailed, err)
+		return err
+	}
+	eng.announceModule(mod.GetName(), ModuleStarted, nil)
+	return nil
+}
+
+// Shut down a module, announcing on nerv.internal.modules
+// once it has or once its shutdown timeout has passed
+func (eng *Engine) shutdownModule(mod Module) error {
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		mod.Shutdown()
+	}()
+
+	timer := time.NewTimer(eng.shutdownTimeoutOf(mod))
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-done:
+	case <-timer.C:
+		err = ErrEngineModuleTimeout
+	}
+
+	eng.announceModule(mod.GetName(), ModuleStopped, err)
+	return err
+}
@@ -0,0 +1,253 @@
+package nerv
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errOrderedModule = errors.New("ordered module failed to start")
+
+type orderedModule struct {
+	name    string
+	deps    []string
+	fail    bool
+	stall   time.Duration
+	timeout time.Duration
+	journal *moduleJournal
+}
+
+type moduleJournal struct {
+	entries []string
+	mu      sync.Mutex
+}
+
+func (m *orderedModule) record(entry string) {
+	m.journal.mu.Lock()
+	defer m.journal.mu.Unlock()
+	m.journal.entries = append(m.journal.entries, entry)
+}
+
+func (m *orderedModule) GetName() string {
+	return m.name
+}
+
+func (m *orderedModule) Dependencies() []string {
+	return m.deps
+}
+
+func (m *orderedModule) ShutdownTimeout() time.Duration {
+	return m.timeout
+}
+
+func (m *orderedModule) RecvModulePane(pane *ModulePane) {}
+
+func (m *orderedModule) Start() error {
+	if m.fail {
+		return errOrderedModule
+	}
+	m.record("start:" + m.name)
+	return nil
+}
+
+func (m *orderedModule) Shutdown() {
+	time.Sleep(m.stall)
+	m.record("stop:" + m.name)
+}
+
+func setupOrderedModules(modules ...*orderedModule) (*Engine, *moduleJournal) {
+	engine := NewEngine().WithModuleShutdownTimeout(50 * time.Millisecond)
+	journal := &moduleJournal{entries: make([]string, 0)}
+	for _, mod := range modules {
+		mod.journal = journal
+		engine.UseModule(mod, []*TopicCfg{})
+	}
+	return engine, journal
+}
+
+func expectJournal(t *testing.T, journal *moduleJournal, expected ...string) {
+	journal.mu.Lock()
+	defer journal.mu.Unlock()
+	if len(journal.entries) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, journal.entries)
+	}
+	for i := range expected {
+		if journal.entries[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, journal.entries)
+		}
+	}
+}
+
+func TestModuleOrdering(t *testing.T) {
+
+	engine, journal := setupOrderedModules(
+		&orderedModule{name: "api", deps: []string{"db", "cache"}},
+		&orderedModule{name: "cache", deps: []string{"db"}},
+		&orderedModule{name: "db"},
+		&orderedModule{name: "metrics"},
+	)
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	expectJournal(t, journal,
+		"start:db", "start:cache", "start:api", "start:metrics",
+		"stop:metrics", "stop:api", "stop:cache", "stop:db")
+}
+
+func TestModuleShutdownTimeout(t *testing.T) {
+
+	engine, journal := setupOrderedModules(
+		&orderedModule{name: "db", stall: 100 * time.Millisecond, timeout: time.Second},
+		&orderedModule{name: "cache", stall: 100 * time.Millisecond},
+	)
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Only the module without a timeout of its own is given up on
+	err := engine.Stop()
+	if !errors.Is(err, ErrEngineModuleTimeout) || strings.Contains(err.Error(), "module db") {
+		t.Fatalf("expected only cache to time out, got %v", err)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	expectJournal(t, journal,
+		"start:db", "start:cache", "stop:cache", "stop:db")
+}
+
+func TestModuleStartRollback(t *testing.T) {
+
+	engine, journal := setupOrderedModules(
+		&orderedModule{name: "db"},
+		&orderedModule{name: "cache", deps: []string{"db"}, stall: 200 * time.Millisecond},
+		&orderedModule{name: "api", deps: []string{"cache"}, fail: true},
+	)
+
+	err := engine.Start()
+	if !errors.Is(err, errOrderedModule) {
+		t.Fatalf("expected start failure, got %v", err)
+	}
+
+	if !errors.Is(err, ErrEngineModuleTimeout) {
+		t.Fatalf("expected rollback timeout to be reported, got %v", err)
+	}
+
+	if err := engine.Stop(); err != ErrEngineNotRunning {
+		t.Fatalf("expected engine to be left stopped, got %v", err)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+
+	expectJournal(t, journal,
+		"start:db", "start:cache", "stop:db", "stop:cache")
+}
+
+func TestModuleDependencyErrors(t *testing.T) {
+
+	engine, _ := setupOrderedModules(
+		&orderedModule{name: "a", deps: []string{"b"}},
+		&orderedModule{name: "b", deps: []string{"a"}},
+	)
+
+	if err := engine.Start(); !errors.Is(err, ErrEngineModuleCycle) {
+		t.Fatalf("expected cycle, got %v", err)
+	}
+
+	engine, _ = setupOrderedModules(
+		&orderedModule{name: "a", deps: []string{"missing"}},
+	)
+
+	if err := engine.Start(); !errors.Is(err, ErrEngineUnknownModule) {
+		t.Fatalf("expected unknown module, got %v", err)
+	}
+}
+
+type hotModule struct {
+	orderedModule
+	pane  *ModulePane
+	recvd atomic.Int32
+}
+
+func (m *hotModule) RecvModulePane(pane *ModulePane) {
+	m.pane = pane
+}
+
+func (m *hotModule) Start() error {
+	if err := m.orderedModule.Start(); err != nil {
+		return err
+	}
+	return m.pane.SubscribeTo("hot.topic", []Consumer{
+		{
+			Id: "hot.consumer",
+			Fn: func(event *Event) {
+				m.recvd.Add(1)
+			},
+		},
+	}, true)
+}
+
+func TestModuleHotSwap(t *testing.T) {
+
+	engine, journal := setupOrderedModules(&orderedModule{name: "db"})
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	failing := &hotModule{orderedModule: orderedModule{name: "failing", fail: true, journal: journal}}
+	if err := engine.UseModule(failing, []*TopicCfg{NewTopic("failing.topic")}); !errors.Is(err, errOrderedModule) {
+		t.Fatalf("expected start failure, got %v", err)
+	}
+
+	failingTopic := "failing.topic"
+	if engine.ContainsTopic(&failingTopic) || engine.SetModuleMeta("failing", 1) != ErrEngineUnknownModule {
+		t.Fatal("module that failed to start should have been removed")
+	}
+
+	hot := &hotModule{orderedModule: orderedModule{name: "hot", deps: []string{"db"}, journal: journal}}
+	if err := engine.UseModule(hot, []*TopicCfg{NewTopic("hot.topic")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.UseModule(hot, []*TopicCfg{}); err != ErrEngineDuplicateModule {
+		t.Fatalf("expected duplicate module, got %v", err)
+	}
+
+	engine.Submit("test", "hot.topic", 1)
+	time.Sleep(50 * time.Millisecond)
+
+	if err := engine.RemoveModule("db", false); !errors.Is(err, ErrEngineModuleRequired) {
+		t.Fatalf("expected db to be required, got %v", err)
+	}
+
+	if err := engine.RemoveModule("hot", true); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	topic := "hot.topic"
+	consumer := "hot.consumer"
+	if engine.ContainsTopic(&topic) || engine.ContainsConsumer(&consumer) {
+		t.Fatal("expected module topic and consumer to be removed")
+	}
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if hot.recvd.Load() != 1 {
+		t.Fatalf("expected hot module to receive 1 event, got %d", hot.recvd.Load())
+	}
+
+	expectJournal(t, journal, "start:db", "start:hot", "stop:hot", "stop:db")
+}
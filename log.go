@@ -0,0 +1,211 @@
+package nerv
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+const (
+	startLatest = iota
+	startEarliest
+	startOffset
+	startTime
+)
+
+var ErrTopicNotLogged = errors.New("topic does not retain a log")
+
+// Configuration of the log backing a topic. A zero MaxAge or MaxEvents
+// means that the log is not bounded by that measure
+type LogCfg struct {
+	MaxAge    time.Duration
+	MaxEvents int
+
+	// When set, the log is compacted such that only the most
+	// recent event for any given key is retained
+	CompactionKey func(event *Event) string
+}
+
+// Where in a topic's log a new subscription should begin
+// receiving events from
+type StartPosition struct {
+	kind   int
+	offset uint64
+	at     time.Time
+}
+
+// Only receive events submitted after subscription
+func FromLatest() StartPosition {
+	return StartPosition{kind: startLatest}
+}
+
+// Receive every event still retained by the topic
+func FromEarliest() StartPosition {
+	return StartPosition{kind: startEarliest}
+}
+
+// Receive retained events starting at (and including) the given offset
+func FromOffset(offset uint64) StartPosition {
+	return StartPosition{kind: startOffset, offset: offset}
+}
+
+// Receive retained events that were logged at or after the given time
+func FromTime(at time.Time) StartPosition {
+	return StartPosition{kind: startTime, at: at}
+}
+
+type logEntry struct {
+	offset   uint64
+	appended time.Time
+	key      string
+	event    *Event
+}
+
+type topicLog struct {
+	cfg     LogCfg
+	entries []*logEntry
+	next    uint64
+	latest  map[string]uint64
+	stale   int
+}
+
+func newTopicLog(cfg LogCfg) *topicLog {
+	return &topicLog{
+		cfg:     cfg,
+		entries: make([]*logEntry, 0),
+		next:    0,
+		latest:  make(map[string]uint64),
+		stale:   0,
+	}
+}
+
+func (l *topicLog) append(event *Event) uint64 {
+
+	now := time.Now()
+
+	event.Offset = l.next
+
+	entry := &logEntry{
+		offset:   l.next,
+		appended: now,
+		event:    event.clone(),
+	}
+
+	l.next += 1
+
+	if l.cfg.CompactionKey != nil {
+		entry.key = l.cfg.CompactionKey(event)
+		if _, ok := l.latest[entry.key]; ok {
+			l.stale += 1
+		}
+		l.latest[entry.key] = entry.offset
+	}
+
+	l.entries = append(l.entries, entry)
+
+	l.enforceRetention(now)
+
+	if l.stale > 0 && l.stale*2 >= len(l.entries) {
+		l.compact()
+	}
+	return entry.offset
+}
+
+func (l *topicLog) superseded(entry *logEntry) bool {
+	if l.cfg.CompactionKey == nil {
+		return false
+	}
+	return l.latest[entry.key] != entry.offset
+}
+
+func (l *topicLog) enforceRetention(now time.Time) {
+
+	drop := 0
+
+	if l.cfg.MaxEvents > 0 && len(l.entries) > l.cfg.MaxEvents {
+		drop = len(l.entries) - l.cfg.MaxEvents
+	}
+
+	if l.cfg.MaxAge > 0 {
+		for drop < len(l.entries) && now.Sub(l.entries[drop].appended) > l.cfg.MaxAge {
+			drop += 1
+		}
+	}
+
+	if drop == 0 {
+		return
+	}
+
+	for _, entry := range l.entries[:drop] {
+		if l.superseded(entry) {
+			l.stale -= 1
+		} else if l.cfg.CompactionKey != nil {
+			delete(l.latest, entry.key)
+		}
+	}
+
+	l.entries = l.entries[drop:]
+}
+
+// Remove all events that have been superseded by a more
+// recent event with the same compaction key
+func (l *topicLog) compact() {
+
+	retained := make([]*logEntry, 0, len(l.entries)-l.stale)
+	for _, entry := range l.entries {
+		if !l.superseded(entry) {
+			retained = append(retained, entry)
+		}
+	}
+
+	l.entries = retained
+	l.stale = 0
+}
+
+// Retrieve the events that a subscriber starting at the given
+// position should be handed before receiving live events
+func (l *topicLog) from(pos StartPosition) []*Event {
+
+	l.enforceRetention(time.Now())
+
+	events := make([]*Event, 0)
+
+	if pos.kind == startLatest {
+		return events
+	}
+
+	for _, entry := range l.entries {
+		if l.superseded(entry) {
+			continue
+		}
+		switch pos.kind {
+		case startOffset:
+			if entry.offset < pos.offset {
+				continue
+			}
+		case startTime:
+			if entry.appended.Before(pos.at) {
+				continue
+			}
+		}
+		events = append(events, entry.event.clone())
+	}
+	return events
+}
+
+// Copy an event, such that those retained by the engine are
+// unaffected by consumers changing the ones handed to them
+func (event *Event) clone() *Event {
+	copied := *event
+	copied.Trail = slices.Clone(event.Trail)
+	return &copied
+}
+
+// Offset of the oldest retained event and the offset that
+// the next logged event will be assigned
+func (l *topicLog) bounds() (uint64, uint64) {
+	if len(l.entries) == 0 {
+		return l.next, l.next
+	}
+	return l.entries[0].offset, l.next
+}
@@ -0,0 +1,288 @@
+package nerv
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+type replayRecvr struct {
+	mu    sync.Mutex
+	recvd []int
+}
+
+func (r *replayRecvr) Accept(event *Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recvd = append(r.recvd, event.Data.(int))
+}
+
+func (r *replayRecvr) get() []int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]int{}, r.recvd...)
+}
+
+func expectSequence(t *testing.T, name string, actual []int, expected []int) {
+	if len(actual) != len(expected) {
+		t.Fatalf("%s: expected %v, got %v", name, expected, actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Fatalf("%s: expected %v, got %v", name, expected, actual)
+		}
+	}
+}
+
+func TestTopicReplay(t *testing.T) {
+
+	topicName := "replayed"
+
+	engine := NewEngine()
+
+	if err := engine.CreateTopic(
+		NewTopic(topicName).
+			UsingBroadcast().
+			UsingRetention(0, 8)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		engine.Submit("producer", topicName, i)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	earliest, next, err := engine.TopicOffsets(topicName)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if earliest != 2 || next != 10 {
+		t.Fatalf("expected offsets [2, 10), got [%d, %d)", earliest, next)
+	}
+
+	fromEarliest := &replayRecvr{}
+	fromOffset := &replayRecvr{}
+	fromLatest := &replayRecvr{}
+	fromTime := &replayRecvr{}
+
+	engine.Register(Consumer{"earliest", fromEarliest.Accept})
+	engine.Register(Consumer{"offset", fromOffset.Accept})
+	engine.Register(Consumer{"latest", fromLatest.Accept})
+	engine.Register(Consumer{"time", fromTime.Accept})
+
+	if err := engine.SubscribeFrom(topicName, FromEarliest(), "earliest"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := engine.SubscribeFrom(topicName, FromOffset(7), "offset"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := engine.SubscribeFrom(topicName, FromLatest(), "latest"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := engine.SubscribeFrom(topicName, FromTime(time.Now()), "time"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	engine.Submit("producer", topicName, 10)
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	expectSequence(t, "earliest", fromEarliest.get(), []int{2, 3, 4, 5, 6, 7, 8, 9, 10})
+	expectSequence(t, "offset", fromOffset.get(), []int{7, 8, 9, 10})
+	expectSequence(t, "latest", fromLatest.get(), []int{10})
+	expectSequence(t, "time", fromTime.get(), []int{10})
+
+	fmt.Println("[REPLAY COMPLETE]")
+}
+
+func TestTopicCompaction(t *testing.T) {
+
+	topicName := "compacted"
+
+	engine := NewEngine()
+
+	if err := engine.CreateTopic(
+		NewTopic(topicName).
+			UsingCompaction(func(event *Event) string {
+				return fmt.Sprintf("%d", event.Data.(int)%3)
+			})); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 9; i++ {
+		engine.Submit("producer", topicName, i)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	recvr := &replayRecvr{}
+	engine.Register(Consumer{"rebuilder", recvr.Accept})
+
+	if err := engine.SubscribeFrom(TopicInternal, FromEarliest(), "rebuilder"); err != ErrTopicNotLogged {
+		t.Fatalf("expected replay of a topic without a log to fail, got %v", err)
+	}
+
+	if err := engine.SubscribeFrom(topicName, FromEarliest(), "rebuilder"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	expectSequence(t, "compacted", recvr.get(), []int{6, 7, 8})
+}
+
+func TestTopicRetentionAge(t *testing.T) {
+
+	log := newTopicLog(LogCfg{MaxAge: 50 * time.Millisecond})
+
+	log.append(&Event{Data: 0})
+	time.Sleep(100 * time.Millisecond)
+	log.append(&Event{Data: 1})
+
+	events := log.from(FromEarliest())
+	if len(events) != 1 || events[0].Data.(int) != 1 {
+		t.Fatalf("expected only the young event to be retained, got %d events", len(events))
+	}
+}
+
+func TestRetainedEventsAreCopied(t *testing.T) {
+
+	topicName := "retained"
+
+	engine := NewEngine()
+
+	if err := engine.CreateTopic(
+		NewTopic(topicName).
+			UsingBroadcast().
+			UsingRetention(0, 8).
+			UsingLastValueCache(1)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	// Changes made by one consumer must not reach the others
+	engine.Register(Consumer{
+		Id: "vandal",
+		Fn: func(event *Event) {
+			event.Data = -1
+		},
+	})
+	engine.SubscribeTo(topicName, "vandal")
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	engine.Submit("producer", topicName, 1)
+
+	time.Sleep(50 * time.Millisecond)
+
+	last, err := engine.LastEvent(topicName)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	last.Data = -2
+
+	replayed := &replayRecvr{}
+	engine.Register(Consumer{
+		Id: "replayed",
+		Fn: replayed.Accept,
+	})
+	if err := engine.SubscribeFrom(topicName, FromEarliest(), "replayed"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	expectSequence(t, "replayed", replayed.get(), []int{1})
+
+	if last, _ = engine.LastEvent(topicName); last.Data.(int) != 1 {
+		t.Fatalf("expected cached event to be unchanged, got %v", last.Data)
+	}
+}
+
+func TestSubscribeFromConsumer(t *testing.T) {
+
+	engine := NewEngine()
+
+	if err := engine.CreateTopic(NewTopic("history").UsingRetention(0, 8)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	if err := engine.CreateTopic(NewTopic("status").UsingLastValueCache(1)); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	if err := engine.CreateTopic(NewTopic("joins")); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	history := &replayRecvr{}
+	status := &replayRecvr{}
+	engine.Register(Consumer{"history.reader", history.Accept})
+	engine.Register(Consumer{"status.reader", status.Accept})
+
+	// Subscribing from within a delivery must not wait on the dispatcher
+	engine.Register(Consumer{
+		Id: "joiner",
+		Fn: func(event *Event) {
+			if err := engine.SubscribeFrom("history", FromEarliest(), "history.reader"); err != nil {
+				t.Errorf("err: %v", err)
+			}
+			if err := engine.SubscribeTo("status", "status.reader"); err != nil {
+				t.Errorf("err: %v", err)
+			}
+		},
+	})
+	engine.SubscribeTo("joins", "joiner")
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		engine.Submit("producer", "history", i)
+		engine.Submit("producer", "status", i)
+	}
+	engine.Submit("producer", "joins", nil)
+
+	time.Sleep(20 * time.Millisecond)
+
+	engine.Submit("producer", "history", 3)
+	engine.Submit("producer", "status", 3)
+
+	stopped := make(chan error)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		stopped <- engine.Stop()
+	}()
+
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("engine deadlocked subscribing from within a consumer")
+	}
+
+	expectSequence(t, "history", history.get(), []int{0, 1, 2, 3})
+	expectSequence(t, "status", status.get(), []int{2, 3})
+}
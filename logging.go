@@ -0,0 +1,149 @@
+package nerv
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+const (
+	defaultEngineName = "nerv"
+)
+
+// Decides what of an event's payload may be logged
+type Redactor func(event *Event) interface{}
+
+// By default only the type of a payload is logged
+func redactPayload(event *Event) interface{} {
+	if event.Data == nil {
+		return nil
+	}
+	return fmt.Sprintf("%T", event.Data)
+}
+
+// Log through the given logger rather than the default that was set when
+// the engine was created. Everything logged is tagged with the engine's name
+func (eng *Engine) WithLogger(logger *slog.Logger) *Engine {
+	eng.logMu.Lock()
+	defer eng.logMu.Unlock()
+
+	eng.baseLogger = logger
+	eng.logger = logger.With("engine", eng.name)
+	return eng
+}
+
+// Name the engine so that what it logs can be told apart
+// from other engines logging through the same handler
+func (eng *Engine) WithName(name string) *Engine {
+	eng.logMu.Lock()
+	defer eng.logMu.Unlock()
+
+	eng.name = name
+	eng.logger = eng.baseLogger.With("engine", name)
+	return eng
+}
+
+// Log a module at a level independent of the engine's logger
+func (eng *Engine) WithModuleLogLevel(module string, level slog.Level) *Engine {
+	eng.logMu.Lock()
+	defer eng.logMu.Unlock()
+
+	eng.moduleLevels[module] = level
+	return eng
+}
+
+// Replace what is logged in place of event payloads
+func (eng *Engine) WithRedaction(fn Redactor) *Engine {
+	eng.logMu.Lock()
+	defer eng.logMu.Unlock()
+
+	eng.redactor = fn
+	return eng
+}
+
+func (eng *Engine) Name() string {
+	eng.logMu.RLock()
+	defer eng.logMu.RUnlock()
+
+	return eng.name
+}
+
+// Retrieve what may be logged of an event's payload
+func (eng *Engine) Redact(event *Event) interface{} {
+	eng.logMu.RLock()
+	redactor := eng.redactor
+	eng.logMu.RUnlock()
+
+	return redactor(event)
+}
+
+func (eng *Engine) log() *slog.Logger {
+	eng.logMu.RLock()
+	defer eng.logMu.RUnlock()
+
+	return eng.logger
+}
+
+func (eng *Engine) moduleLevel(module string) (slog.Level, bool) {
+	eng.logMu.RLock()
+	defer eng.logMu.RUnlock()
+
+	level, ok := eng.moduleLevels[module]
+	return level, ok
+}
+
+// Logger handed to a module. Records go through whichever logger the
+// engine currently has, tagged with the module's name, and are filtered
+// by the module's own level when it has one
+type moduleHandler struct {
+	eng    *Engine
+	module string
+	wrap   []func(slog.Handler) slog.Handler
+}
+
+func newModuleLogger(eng *Engine, module string) *slog.Logger {
+	return slog.New(&moduleHandler{
+		eng:    eng,
+		module: module,
+		wrap:   nil,
+	})
+}
+
+func (h *moduleHandler) handler() slog.Handler {
+	handler := h.eng.log().Handler().WithAttrs([]slog.Attr{slog.String("module", h.module)})
+	for _, wrap := range h.wrap {
+		handler = wrap(handler)
+	}
+	return handler
+}
+
+func (h *moduleHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	if moduleLevel, ok := h.eng.moduleLevel(h.module); ok {
+		return level >= moduleLevel
+	}
+	return h.eng.log().Handler().Enabled(ctx, level)
+}
+
+func (h *moduleHandler) Handle(ctx context.Context, record slog.Record) error {
+	return h.handler().Handle(ctx, record)
+}
+
+func (h *moduleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler {
+		return handler.WithAttrs(attrs)
+	})
+}
+
+func (h *moduleHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler {
+		return handler.WithGroup(name)
+	})
+}
+
+func (h *moduleHandler) with(wrap func(slog.Handler) slog.Handler) slog.Handler {
+	return &moduleHandler{
+		eng:    h.eng,
+		module: h.module,
+		wrap:   append(append([]func(slog.Handler) slog.Handler(nil), h.wrap...), wrap),
+	}
+}
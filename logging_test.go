@@ -0,0 +1,83 @@
+package nerv
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+type loggingModule struct {
+	name string
+	pane *ModulePane
+}
+
+func (m *loggingModule) GetName() string {
+	return m.name
+}
+
+func (m *loggingModule) RecvModulePane(pane *ModulePane) {
+	m.pane = pane
+}
+
+func (m *loggingModule) Start() error {
+	return nil
+}
+
+func (m *loggingModule) Shutdown() {}
+
+type secret struct {
+	Password string
+}
+
+func TestEngineLogging(t *testing.T) {
+
+	out := &bytes.Buffer{}
+
+	engine := NewEngine().
+		WithLogger(slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: slog.LevelInfo}))).
+		WithName("orders").
+		WithModuleLogLevel("chatty", slog.LevelDebug).
+		WithModuleLogLevel("quiet", slog.LevelError)
+
+	chatty := &loggingModule{name: "chatty"}
+	quiet := &loggingModule{name: "quiet"}
+
+	engine.UseModule(chatty, []*TopicCfg{})
+	engine.UseModule(quiet, []*TopicCfg{})
+
+	chatty.pane.Logger.Debug("chatty debug")
+	quiet.pane.Logger.Warn("quiet warning")
+	quiet.pane.Logger.Error("quiet error")
+	engine.log().Debug("engine debug")
+	engine.log().Info("engine info")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 records, got %d: %v", len(lines), lines)
+	}
+
+	for _, line := range lines {
+		if !strings.Contains(line, "engine=orders") {
+			t.Fatalf("record not tagged with engine name: %s", line)
+		}
+	}
+
+	if !strings.Contains(lines[0], "module=chatty") || !strings.Contains(lines[1], "module=quiet") {
+		t.Fatalf("records not tagged with module names: %v", lines)
+	}
+
+	event := &Event{Data: &secret{Password: "hunter2"}}
+
+	if redacted := chatty.pane.Redact(event); redacted != "*nerv.secret" {
+		t.Fatalf("expected only payload type by default, got %v", redacted)
+	}
+
+	engine.WithRedaction(func(event *Event) interface{} {
+		return "***"
+	})
+
+	if redacted := chatty.pane.Redact(event); redacted != "***" {
+		t.Fatalf("expected custom redaction, got %v", redacted)
+	}
+}
@@ -0,0 +1,34 @@
+package nerv
+
+import (
+	"sync/atomic"
+)
+
+// Snapshot of counters kept by the engine since it was created
+type Metrics struct {
+	Submitted    uint64
+	Emitted      uint64
+	Deduplicated uint64
+	RateLimited  uint64
+}
+
+type engineMetrics struct {
+	submitted    atomic.Uint64
+	emitted      atomic.Uint64
+	deduplicated atomic.Uint64
+	rateLimited  atomic.Uint64
+}
+
+func (m *engineMetrics) snapshot() Metrics {
+	return Metrics{
+		Submitted:    m.submitted.Load(),
+		Emitted:      m.emitted.Load(),
+		Deduplicated: m.deduplicated.Load(),
+		RateLimited:  m.rateLimited.Load(),
+	}
+}
+
+// Retrieve the current value of the engine's counters
+func (eng *Engine) Metrics() Metrics {
+	return eng.metrics.snapshot()
+}
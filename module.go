@@ -1,5 +1,10 @@
 package nerv
 
+import (
+	"log/slog"
+	"time"
+)
+
 // Interface used in nerv engine to manage modules
 // loaded in by the user
 type Module interface {
@@ -9,6 +14,26 @@ type Module interface {
 	Shutdown()
 }
 
+// Optional interface for modules that must be started after, and
+// shut down before, the modules named by their dependencies
+type ModuleDependent interface {
+	Dependencies() []string
+}
+
+// Optional interface for modules that need more, or less, time to shut
+// down than the engine's shutdown timeout. Non-positive values use the
+// engine's timeout
+type ShutdownTimed interface {
+	ShutdownTimeout() time.Duration
+}
+
+// Optional interface for modules that can report their own health.
+// A nil error is healthy. When the engine is supervising, unhealthy
+// modules are restarted
+type HealthChecker interface {
+	Health() error
+}
+
 // Interface for module to take action without
 // access to engine object
 type ModulePane struct {
@@ -20,7 +45,8 @@ type ModulePane struct {
 	// Subscribe a set of consumers to a topic. If register is TRUE, then the
 	// engine will be momentarily locked to ensure that the consumer is registered
 	// as subscription requires a registered consumer. It is safe to always pass TRUE
-	// but it map cause performance overhead if its called a lot as such
+	// but it map cause performance overhead if its called a lot as such.
+	// Consumers already subscribed to the topic through the pane are skipped
 	SubscribeTo func(topic string, consumers []Consumer, register bool) error
 
 	// Permits module to submit raw data as an event onto
@@ -31,6 +57,46 @@ type ModulePane struct {
 	// Place an event onto the bus from the module that may or may
 	// not go to consumers of the module. This function is useful
 	// for fowarding events through a module without obfuscating
-	// the original event
-	SubmitEvent EventRecvr
+	// the original event. ErrEngineDuplicateEvent is returned
+	// when the event was dropped by topic deduplication
+	SubmitEvent func(event *Event) error
+
+	// Submit raw data as the module's producer in response to an event
+	// delivered to the module, carrying on the event's hop count such
+	// that the engine can drop events cycling between modules
+	SubmitFrom func(parent *Event, topic string, data interface{}) error
+
+	// Submit raw data onto a topic as the module's producer once the
+	// delay has elapsed. The returned function cancels the submission
+	// if it has yet to happen. Pending submissions are cancelled when
+	// the module is removed
+	SubmitAfter func(delay time.Duration, topic string, data interface{}) (cancel func())
+
+	// Create a topic that is owned by the module. Owned topics may
+	// be deleted by the module, and are deleted along with it when
+	// it is removed from the engine if requested
+	CreateTopic func(cfg *TopicCfg) error
+
+	// Delete a topic owned by the module. ErrEngineModuleNotPermitted
+	// is returned for topics that the module does not own
+	DeleteTopic func(topic string) error
+
+	// Remove a set of the module's consumers from a topic. Only consumers
+	// that were subscribed through the pane may be unsubscribed
+	Unsubscribe func(topic string, consumers ...string) error
+
+	// Store meta information for the module itself
+	SetModuleMeta func(data interface{}) error
+
+	// Query the state of the engine. Aliases of topics are contained
+	ContainsTopic    func(topic string) bool
+	ContainsConsumer func(id string) bool
+	IsRunning        func() bool
+
+	// Logger that tags everything logged with the engine's and the
+	// module's names, at the level set for the module if there is one
+	Logger *slog.Logger
+
+	// Retrieve what may be logged of an event's payload
+	Redact Redactor
 }
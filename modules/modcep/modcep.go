@@ -0,0 +1,343 @@
+/*
+  This module performs complex event processing. Patterns describing
+  sequences of events across topics are registered with the module, and
+  whenever one is observed a synthetic match event is published.
+*/
+
+package modcep
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bosley/nerv-go"
+	"sync"
+	"time"
+)
+
+const (
+	defaultName = "nerv.mod.cep"
+)
+
+var ErrInvalidPattern = errors.New("pattern requires a name, at least one step and an output topic")
+var ErrModuleStarted = errors.New("patterns must be registered before the module starts")
+
+// A single step of a pattern. An event satisfies the step if it
+// was submitted to Topic and passes Where, if given
+type Step struct {
+	Topic string
+	Where func(event *nerv.Event) bool
+}
+
+// A pattern is a sequence of steps that must be observed in order
+// within the given duration, from events that share a correlation key.
+// If any of the Absent steps are observed after the first step has
+// matched, the partial match is abandoned
+type Pattern struct {
+	Name     string
+	Sequence []Step
+	Absent   []Step
+	Within   time.Duration
+
+	// Require all events of a match to come from the same producer
+	SameProducer bool
+
+	// Correlation key for the events of a match. Overrides SameProducer
+	Correlate func(event *nerv.Event) string
+
+	// Topic that matches are published on
+	Output string
+}
+
+// Data of the event published when a pattern is matched
+type Match struct {
+	Pattern string
+	Key     string
+	Events  []*nerv.Event
+}
+
+type partialMatch struct {
+	started time.Time
+	events  []*nerv.Event
+}
+
+type registeredPattern struct {
+	pattern Pattern
+	partial map[string][]*partialMatch
+}
+
+// Module performing the pattern detection
+type Module struct {
+	name     string
+	pane     *nerv.ModulePane
+	patterns []*registeredPattern
+	started  bool
+	mu       sync.Mutex
+
+	// Closed to stop the sweeping of expired partial matches
+	sweeping chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Create a cep module. If name is empty a default is used
+func New(name string) *Module {
+	if len(name) == 0 {
+		name = defaultName
+	}
+	return &Module{
+		name:     name,
+		pane:     nil,
+		patterns: make([]*registeredPattern, 0),
+	}
+}
+
+func (s Step) matches(event *nerv.Event) bool {
+	if event.Topic != s.Topic {
+		return false
+	}
+	return s.Where == nil || s.Where(event)
+}
+
+func (p *Pattern) key(event *nerv.Event) string {
+	if p.Correlate != nil {
+		return p.Correlate(event)
+	}
+	if p.SameProducer {
+		return event.Producer
+	}
+	return ""
+}
+
+// Register a pattern to be detected once the module starts
+func (m *Module) Register(p Pattern) error {
+
+	if len(p.Name) == 0 || len(p.Sequence) == 0 || len(p.Output) == 0 {
+		return ErrInvalidPattern
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.started {
+		return ErrModuleStarted
+	}
+
+	m.patterns = append(m.patterns, &registeredPattern{
+		pattern: p,
+		partial: make(map[string][]*partialMatch),
+	})
+	return nil
+}
+
+// Configuration of the output topics of all registered patterns,
+// suitable for handing to Engine.UseModule
+func (m *Module) Topics() []*nerv.TopicCfg {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	topics := make([]*nerv.TopicCfg, 0)
+	seen := make(map[string]bool)
+	for _, rp := range m.patterns {
+		if seen[rp.pattern.Output] {
+			continue
+		}
+		seen[rp.pattern.Output] = true
+		topics = append(topics, nerv.NewTopic(rp.pattern.Output))
+	}
+	return topics
+}
+
+func (m *Module) GetName() string {
+	return m.name
+}
+
+func (m *Module) RecvModulePane(p *nerv.ModulePane) {
+	if m.pane != nil {
+		return
+	}
+	m.pane = p
+}
+
+// Subscribes to every topic that a registered pattern depends on
+func (m *Module) Start() error {
+
+	if m.pane == nil {
+		return errors.New("no module pane for cep. did Start() run before module registration?")
+	}
+
+	m.pane.Logger.Info("modcep:start", "name", m.name)
+
+	m.mu.Lock()
+	m.started = true
+	topics := make(map[string]bool)
+	var interval time.Duration
+	for _, rp := range m.patterns {
+		for _, step := range rp.pattern.Sequence {
+			topics[step.Topic] = true
+		}
+		for _, step := range rp.pattern.Absent {
+			topics[step.Topic] = true
+		}
+		if within := rp.pattern.Within; within > 0 && (interval == 0 || within < interval) {
+			interval = within
+		}
+	}
+
+	// Partial matches are otherwise only expired when their key
+	// is seen again, which for many keys it never will be
+	if interval > 0 && m.sweeping == nil {
+		m.sweeping = make(chan struct{})
+		m.wg.Add(1)
+		go m.sweep(interval, m.sweeping)
+	}
+	m.mu.Unlock()
+
+	for topic := range topics {
+		consumer := nerv.Consumer{
+			Id: fmt.Sprintf("%s:%s", m.name, topic),
+			Fn: m.observe,
+		}
+		if err := m.pane.SubscribeTo(topic, []nerv.Consumer{consumer}, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Abandons all partial matches
+func (m *Module) Shutdown() {
+
+	m.pane.Logger.Info("modcep:shutdown", "name", m.name)
+
+	m.mu.Lock()
+	if m.sweeping != nil {
+		close(m.sweeping)
+		m.sweeping = nil
+	}
+	for _, rp := range m.patterns {
+		rp.partial = make(map[string][]*partialMatch)
+	}
+	m.mu.Unlock()
+
+	m.wg.Wait()
+}
+
+// Every interval, discard the partial matches that can no longer complete
+func (m *Module) sweep(interval time.Duration, stop chan struct{}) {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			m.mu.Lock()
+			for _, rp := range m.patterns {
+				rp.expire(now)
+			}
+			m.mu.Unlock()
+		}
+	}
+}
+
+func (rp *registeredPattern) expire(now time.Time) {
+
+	if rp.pattern.Within <= 0 {
+		return
+	}
+
+	for key, partials := range rp.partial {
+		remaining := partials[:0]
+		for _, partial := range partials {
+			if now.Sub(partial.started) <= rp.pattern.Within {
+				remaining = append(remaining, partial)
+			}
+		}
+		if len(remaining) == 0 {
+			delete(rp.partial, key)
+		} else {
+			clear(partials[len(remaining):])
+			rp.partial[key] = remaining
+		}
+	}
+}
+
+func (m *Module) observe(event *nerv.Event) {
+
+	m.mu.Lock()
+	matches := make(map[*Match]string)
+	for _, rp := range m.patterns {
+		if match := rp.observe(event, time.Now()); match != nil {
+			matches[match] = rp.pattern.Output
+		}
+	}
+	m.mu.Unlock()
+
+	for match, output := range matches {
+		m.pane.Logger.Debug("modcep:match", "pattern", match.Pattern, "key", match.Key)
+		m.pane.SubmitFrom(event, output, match)
+	}
+}
+
+// Advance the partial matches for the event's key, returning
+// a match if the event completed one
+func (rp *registeredPattern) observe(event *nerv.Event, now time.Time) *Match {
+
+	p := &rp.pattern
+	key := p.key(event)
+
+	for _, absent := range p.Absent {
+		if absent.matches(event) {
+			delete(rp.partial, key)
+			return nil
+		}
+	}
+
+	var completed *partialMatch
+	remaining := make([]*partialMatch, 0, len(rp.partial[key])+1)
+
+	for _, partial := range rp.partial[key] {
+		if p.Within > 0 && now.Sub(partial.started) > p.Within {
+			continue
+		}
+		if completed == nil && p.Sequence[len(partial.events)].matches(event) {
+			partial.events = append(partial.events, event)
+			if len(partial.events) == len(p.Sequence) {
+				completed = partial
+				continue
+			}
+		}
+		remaining = append(remaining, partial)
+	}
+
+	if completed == nil && p.Sequence[0].matches(event) {
+		partial := &partialMatch{
+			started: now,
+			events:  []*nerv.Event{event},
+		}
+		if len(p.Sequence) == 1 {
+			completed = partial
+		} else {
+			remaining = append(remaining, partial)
+		}
+	}
+
+	if len(remaining) == 0 {
+		delete(rp.partial, key)
+	} else {
+		rp.partial[key] = remaining
+	}
+
+	if completed == nil {
+		return nil
+	}
+
+	return &Match{
+		Pattern: p.Name,
+		Key:     key,
+		Events:  completed.events,
+	}
+}
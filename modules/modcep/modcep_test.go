@@ -0,0 +1,165 @@
+package modcep
+
+import (
+	"github.com/bosley/nerv-go"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPatternDetection(t *testing.T) {
+
+	engine := nerv.NewEngine()
+
+	alerts := []string{"event.heat-alert", "event.water-alert", "event.movement"}
+	for _, topic := range alerts {
+		if err := engine.CreateTopic(nerv.NewTopic(topic)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	mod := New("")
+
+	if err := mod.Register(Pattern{Name: "incomplete"}); err != ErrInvalidPattern {
+		t.Fatalf("expected invalid pattern, got %v", err)
+	}
+
+	if err := mod.Register(Pattern{
+		Name: "flood-after-fire",
+		Sequence: []Step{
+			{Topic: "event.heat-alert"},
+			{Topic: "event.water-alert"},
+		},
+		Absent: []Step{
+			{Topic: "event.movement"},
+		},
+		Within:       200 * time.Millisecond,
+		SameProducer: true,
+		Output:       "event.sprinkler-discharge",
+	}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	engine.UseModule(mod, mod.Topics())
+
+	matchMu := new(sync.Mutex)
+	matches := make([]*Match, 0)
+
+	engine.Register(nerv.Consumer{
+		Id: "sprinkler.monitor",
+		Fn: func(event *nerv.Event) {
+			matchMu.Lock()
+			defer matchMu.Unlock()
+			matches = append(matches, event.Data.(*Match))
+		},
+	})
+
+	if err := engine.SubscribeTo("event.sprinkler-discharge", "sprinkler.monitor"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Matches: same room, in order, nothing in between
+	engine.Submit("room.a", "event.heat-alert", "hot")
+	engine.Submit("room.a", "event.water-alert", "wet")
+
+	// Different producers never correlate
+	engine.Submit("room.b", "event.heat-alert", "hot")
+	engine.Submit("room.c", "event.water-alert", "wet")
+
+	// Someone moved in between
+	engine.Submit("room.d", "event.heat-alert", "hot")
+	engine.Submit("room.d", "event.movement", "person")
+	engine.Submit("room.d", "event.water-alert", "wet")
+
+	// Too slow
+	engine.Submit("room.e", "event.heat-alert", "hot")
+	time.Sleep(300 * time.Millisecond)
+	engine.Submit("room.e", "event.water-alert", "wet")
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	matchMu.Lock()
+	defer matchMu.Unlock()
+
+	if len(matches) != 1 {
+		t.Fatalf("expected exactly one match, got %d", len(matches))
+	}
+
+	match := matches[0]
+	if match.Pattern != "flood-after-fire" || match.Key != "room.a" || len(match.Events) != 2 {
+		t.Fatalf("unexpected match %+v", match)
+	}
+
+	if err := mod.Register(Pattern{Name: "late", Sequence: []Step{{Topic: "x"}}, Output: "y"}); err != ErrModuleStarted {
+		t.Fatalf("expected registration after start to fail, got %v", err)
+	}
+}
+
+func TestExpiredPartialsSwept(t *testing.T) {
+
+	engine := nerv.NewEngine()
+
+	for _, topic := range []string{"event.login", "event.purchase"} {
+		if err := engine.CreateTopic(nerv.NewTopic(topic)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	mod := New("")
+
+	if err := mod.Register(Pattern{
+		Name: "quick-purchase",
+		Sequence: []Step{
+			{Topic: "event.login"},
+			{Topic: "event.purchase"},
+		},
+		Within:       50 * time.Millisecond,
+		SameProducer: true,
+		Output:       "event.quick-purchase",
+	}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.UseModule(mod, mod.Topics()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// None of these users are ever seen again
+	for _, user := range []string{"user.a", "user.b", "user.c"} {
+		engine.Submit(user, "event.login", nil)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	partials := func() int {
+		mod.mu.Lock()
+		defer mod.mu.Unlock()
+		return len(mod.patterns[0].partial)
+	}
+
+	if n := partials(); n != 3 {
+		t.Fatalf("expected a partial match per user, got %d", n)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	if n := partials(); n != 0 {
+		t.Fatalf("expected expired partial matches to be swept, got %d", n)
+	}
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
@@ -0,0 +1,7 @@
+module github.com/bosley/nerv-go/modules/modfsm
+
+go 1.22.2
+
+replace github.com/bosley/nerv-go => ../../
+
+require github.com/bosley/nerv-go v0.0.0-00010101000000-000000000000
@@ -0,0 +1,297 @@
+/*
+  This module runs declaratively defined finite state machines that
+  are driven by events. Each machine keeps one instance per entity key,
+  and every transition taken is published on the module's transition topic.
+*/
+
+package modfsm
+
+import (
+	"errors"
+	"fmt"
+	"github.com/bosley/nerv-go"
+	"sync"
+)
+
+const (
+	defaultName = "nerv.mod.fsm"
+
+	// Transitions with this as their From state apply to every state
+	AnyState = "*"
+)
+
+var ErrInvalidMachine = errors.New("machine requires a name, an initial state and transitions between known states")
+var ErrDuplicateMachine = errors.New("duplicate machine")
+var ErrUnknownMachine = errors.New("unknown machine")
+var ErrModuleStarted = errors.New("machines must be defined before the module starts")
+
+// Handed to entry and exit actions so that they
+// may publish events as the machine's producer
+type ActionContext struct {
+	Machine string
+	Key     string
+	From    string
+	To      string
+	Trigger *nerv.Event
+	Submit  func(topic string, data interface{})
+}
+
+type Action func(ctx *ActionContext)
+
+type State struct {
+	Name    string
+	OnEntry Action
+	OnExit  Action
+}
+
+// A transition is taken when an instance is in the From state and an
+// event arrives on Topic that satisfies When, if given. Transitions are
+// considered in the order they were declared and only the first is taken
+type Transition struct {
+	From  string
+	To    string
+	Topic string
+	When  func(event *nerv.Event) bool
+}
+
+// A machine definition. Key determines the entity an event belongs to,
+// and so which instance of the machine it drives. A nil Key drives a
+// single instance for all events. New instances begin in Initial without
+// running its entry action
+type Machine struct {
+	Name        string
+	Initial     string
+	States      []State
+	Transitions []Transition
+	Key         func(event *nerv.Event) string
+}
+
+// Data of the event published whenever a transition is taken
+type TransitionEvent struct {
+	Machine string
+	Key     string
+	From    string
+	To      string
+	Trigger *nerv.Event
+}
+
+type definedMachine struct {
+	machine   Machine
+	states    map[string]*State
+	instances map[string]string
+}
+
+// Module running the machines
+type Module struct {
+	name     string
+	pane     *nerv.ModulePane
+	machines map[string]*definedMachine
+	started  bool
+	mu       sync.Mutex
+}
+
+// Create an fsm module. If name is empty a default is used
+func New(name string) *Module {
+	if len(name) == 0 {
+		name = defaultName
+	}
+	return &Module{
+		name:     name,
+		pane:     nil,
+		machines: make(map[string]*definedMachine),
+	}
+}
+
+// Topic that every transition taken is published on
+func (m *Module) TransitionTopic() string {
+	return fmt.Sprintf("%s.transitions", m.name)
+}
+
+// Configuration of the transition topic, suitable
+// for handing to Engine.UseModule
+func (m *Module) Topics() []*nerv.TopicCfg {
+	return []*nerv.TopicCfg{
+		nerv.NewTopic(m.TransitionTopic()),
+	}
+}
+
+// Define a machine to be run once the module starts
+func (m *Module) Define(machine Machine) error {
+
+	if len(machine.Name) == 0 || len(machine.Initial) == 0 || len(machine.Transitions) == 0 {
+		return ErrInvalidMachine
+	}
+
+	dm := &definedMachine{
+		machine:   machine,
+		states:    make(map[string]*State),
+		instances: make(map[string]string),
+	}
+
+	for i := range machine.States {
+		dm.states[machine.States[i].Name] = &machine.States[i]
+	}
+
+	known := func(state string) bool {
+		if len(machine.States) == 0 {
+			return true
+		}
+		_, ok := dm.states[state]
+		return ok
+	}
+
+	if !known(machine.Initial) {
+		return ErrInvalidMachine
+	}
+
+	for _, t := range machine.Transitions {
+		if len(t.Topic) == 0 || !known(t.To) || (t.From != AnyState && !known(t.From)) {
+			return ErrInvalidMachine
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.started {
+		return ErrModuleStarted
+	}
+
+	if _, ok := m.machines[machine.Name]; ok {
+		return ErrDuplicateMachine
+	}
+
+	m.machines[machine.Name] = dm
+	return nil
+}
+
+// Retrieve the current state of a machine's instance
+func (m *Module) State(machine string, key string) (string, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dm, ok := m.machines[machine]
+	if !ok {
+		return "", ErrUnknownMachine
+	}
+
+	state, ok := dm.instances[key]
+	if !ok {
+		return dm.machine.Initial, nil
+	}
+	return state, nil
+}
+
+func (m *Module) GetName() string {
+	return m.name
+}
+
+func (m *Module) RecvModulePane(p *nerv.ModulePane) {
+	if m.pane != nil {
+		return
+	}
+	m.pane = p
+}
+
+// Subscribes to every topic that a transition of a defined machine is triggered by
+func (m *Module) Start() error {
+
+	if m.pane == nil {
+		return errors.New("no module pane for fsm. did Start() run before module registration?")
+	}
+
+	m.pane.Logger.Info("modfsm:start", "name", m.name)
+
+	m.mu.Lock()
+	m.started = true
+	topics := make(map[string]bool)
+	for _, dm := range m.machines {
+		for _, t := range dm.machine.Transitions {
+			topics[t.Topic] = true
+		}
+	}
+	m.mu.Unlock()
+
+	for topic := range topics {
+		consumer := nerv.Consumer{
+			Id: fmt.Sprintf("%s:%s", m.name, topic),
+			Fn: m.observe,
+		}
+		if err := m.pane.SubscribeTo(topic, []nerv.Consumer{consumer}, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Instances are kept so that machines continue where they left off on restart
+func (m *Module) Shutdown() {
+	m.pane.Logger.Info("modfsm:shutdown", "name", m.name)
+}
+
+func (m *Module) observe(event *nerv.Event) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, dm := range m.machines {
+		m.step(dm, event)
+	}
+}
+
+// Take the first transition of the machine that the event
+// triggers, if any. Expects m.mu to be held
+func (m *Module) step(dm *definedMachine, event *nerv.Event) {
+
+	key := ""
+	if dm.machine.Key != nil {
+		key = dm.machine.Key(event)
+	}
+
+	current, ok := dm.instances[key]
+	if !ok {
+		current = dm.machine.Initial
+	}
+
+	for _, t := range dm.machine.Transitions {
+		if t.Topic != event.Topic || (t.From != current && t.From != AnyState) {
+			continue
+		}
+		if t.When != nil && !t.When(event) {
+			continue
+		}
+
+		m.pane.Logger.Debug("modfsm:transition", "machine", dm.machine.Name, "key", key, "from", current, "to", t.To)
+
+		ctx := &ActionContext{
+			Machine: dm.machine.Name,
+			Key:     key,
+			From:    current,
+			To:      t.To,
+			Trigger: event,
+			Submit: func(topic string, data interface{}) {
+				m.pane.SubmitFrom(event, topic, data)
+			},
+		}
+
+		if state, ok := dm.states[current]; ok && state.OnExit != nil {
+			state.OnExit(ctx)
+		}
+
+		dm.instances[key] = t.To
+
+		if state, ok := dm.states[t.To]; ok && state.OnEntry != nil {
+			state.OnEntry(ctx)
+		}
+
+		m.pane.SubmitFrom(event, m.TransitionTopic(), &TransitionEvent{
+			Machine: dm.machine.Name,
+			Key:     key,
+			From:    current,
+			To:      t.To,
+			Trigger: event,
+		})
+		return
+	}
+}
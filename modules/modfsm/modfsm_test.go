@@ -0,0 +1,137 @@
+package modfsm
+
+import (
+	"github.com/bosley/nerv-go"
+	"sync"
+	"testing"
+	"time"
+)
+
+type doorCmd struct {
+	Door   string
+	Action string
+}
+
+func doorAction(action string) func(event *nerv.Event) bool {
+	return func(event *nerv.Event) bool {
+		return event.Data.(*doorCmd).Action == action
+	}
+}
+
+func TestMachine(t *testing.T) {
+
+	engine := nerv.NewEngine()
+
+	for _, topic := range []string{"door.cmd", "door.alarm"} {
+		if err := engine.CreateTopic(nerv.NewTopic(topic)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	mod := New("")
+
+	if err := mod.Define(Machine{Name: "broken", Initial: "nowhere"}); err != ErrInvalidMachine {
+		t.Fatalf("expected invalid machine, got %v", err)
+	}
+
+	if err := mod.Define(Machine{
+		Name:    "door",
+		Initial: "closed",
+		States: []State{
+			{Name: "closed"},
+			{Name: "open"},
+			{
+				Name: "locked",
+				OnEntry: func(ctx *ActionContext) {
+					ctx.Submit("door.alarm", "armed:"+ctx.Key)
+				},
+			},
+		},
+		Transitions: []Transition{
+			{From: "closed", To: "open", Topic: "door.cmd", When: doorAction("open")},
+			{From: "open", To: "closed", Topic: "door.cmd", When: doorAction("close")},
+			{From: "closed", To: "locked", Topic: "door.cmd", When: doorAction("lock")},
+			{From: "locked", To: "closed", Topic: "door.cmd", When: doorAction("unlock")},
+		},
+		Key: func(event *nerv.Event) string {
+			return event.Data.(*doorCmd).Door
+		},
+	}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	engine.UseModule(mod, mod.Topics())
+
+	recvdMu := new(sync.Mutex)
+	transitions := make([]*TransitionEvent, 0)
+	alarms := make([]string, 0)
+
+	engine.Register(nerv.Consumer{
+		Id: "transitions",
+		Fn: func(event *nerv.Event) {
+			recvdMu.Lock()
+			defer recvdMu.Unlock()
+			transitions = append(transitions, event.Data.(*TransitionEvent))
+		},
+	})
+
+	engine.Register(nerv.Consumer{
+		Id: "alarms",
+		Fn: func(event *nerv.Event) {
+			recvdMu.Lock()
+			defer recvdMu.Unlock()
+			alarms = append(alarms, event.Data.(string))
+		},
+	})
+
+	if err := engine.SubscribeTo(mod.TransitionTopic(), "transitions"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := engine.SubscribeTo("door.alarm", "alarms"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for _, cmd := range []*doorCmd{
+		{"front", "open"},
+		{"front", "lock"}, // can't lock an open door
+		{"back", "lock"},
+		{"front", "close"},
+	} {
+		engine.Submit("keypad", "door.cmd", cmd)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for door, expected := range map[string]string{
+		"front":  "closed",
+		"back":   "locked",
+		"garage": "closed",
+	} {
+		state, err := mod.State("door", door)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		if state != expected {
+			t.Fatalf("expected %s door to be %s, got %s", door, expected, state)
+		}
+	}
+
+	recvdMu.Lock()
+	defer recvdMu.Unlock()
+
+	if len(transitions) != 3 {
+		t.Fatalf("expected 3 transitions, got %d", len(transitions))
+	}
+
+	if len(alarms) != 1 || alarms[0] != "armed:back" {
+		t.Fatalf("expected entry action to arm back door, got %v", alarms)
+	}
+}
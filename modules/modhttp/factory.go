@@ -0,0 +1,125 @@
+package modhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bosley/nerv-go"
+	"time"
+)
+
+const (
+	// Kind of module that Factory creates in engine configurations
+	FactoryKind = "http"
+
+	AuthModeNone  = "none"
+	AuthModeToken = "token"
+
+	// Producer that submissions are made as when their token was
+	// given no identity while other tokens were
+	AnonymousProducer = "nerv.mod.http.anonymous"
+
+	defaultGracefulShutdownDuration = 5 * time.Second
+)
+
+var ErrInvalidSettings = errors.New("invalid http module settings")
+
+func init() {
+	nerv.RegisterModuleFactory(FactoryKind, Factory)
+}
+
+// Settings of a module described in an engine configuration
+type Settings struct {
+	Address string        `json:"address"`
+	Grace   nerv.Duration `json:"grace,omitempty"`
+	Auth    *AuthSettings `json:"auth,omitempty"`
+
+	ClientRateLimit *nerv.RateLimit  `json:"client_rate_limit,omitempty"`
+	Topics          []nerv.TopicSpec `json:"topics,omitempty"`
+}
+
+// How submissions are authorized. With the token mode a submission's Auth
+// must be one of the given tokens, or one of those given an identity.
+// Submissions made with a token that has an identity are submitted as
+// that producer, and once any token has one, those without are submitted
+// as AnonymousProducer rather than the producer the request claims
+type AuthSettings struct {
+	Mode       string            `json:"mode"`
+	Tokens     []string          `json:"tokens,omitempty"`
+	Identities map[string]string `json:"identities,omitempty"`
+}
+
+// Create a module from the settings given to it in an engine configuration
+func Factory(settings json.RawMessage) (nerv.Module, error) {
+
+	// Held to the same strictness as the configuration they're part of
+	var s Settings
+	decoder := json.NewDecoder(bytes.NewReader(settings))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&s); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidSettings, err)
+	}
+
+	if len(s.Address) == 0 {
+		return nil, fmt.Errorf("%w: missing address", ErrInvalidSettings)
+	}
+
+	cfg := Config{
+		Address:                  s.Address,
+		GracefulShutdownDuration: time.Duration(s.Grace),
+		ClientRateLimit:          s.ClientRateLimit,
+	}
+
+	if cfg.GracefulShutdownDuration == 0 {
+		cfg.GracefulShutdownDuration = defaultGracefulShutdownDuration
+	}
+
+	for _, spec := range s.Topics {
+		topic, err := spec.TopicCfg()
+		if err != nil {
+			return nil, err
+		}
+		cfg.Topics = append(cfg.Topics, topic)
+	}
+
+	if s.Auth != nil {
+		switch s.Auth.Mode {
+		case AuthModeNone:
+		case AuthModeToken:
+			tokens := append([]string{}, s.Auth.Tokens...)
+			for token := range s.Auth.Identities {
+				tokens = append(tokens, token)
+			}
+			cfg.AuthCb = tokenAuth(tokens)
+			if len(s.Auth.Identities) > 0 {
+				cfg.IdentityCb = tokenIdentity(s.Auth.Identities)
+			}
+		default:
+			return nil, fmt.Errorf("%w: unknown auth mode %s", ErrInvalidSettings, s.Auth.Mode)
+		}
+	}
+
+	return New(cfg), nil
+}
+
+func tokenAuth(tokens []string) AuthCb {
+	permitted := make(map[string]bool)
+	for _, token := range tokens {
+		permitted[token] = true
+	}
+	return func(req *RequestEventSubmission) bool {
+		token, ok := req.Auth.(string)
+		return ok && permitted[token]
+	}
+}
+
+func tokenIdentity(identities map[string]string) IdentityCb {
+	return func(req *RequestEventSubmission) (string, bool) {
+		token, _ := req.Auth.(string)
+		if producer, ok := identities[token]; ok {
+			return producer, true
+		}
+		return AnonymousProducer, true
+	}
+}
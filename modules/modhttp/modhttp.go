@@ -14,8 +14,8 @@ import (
 	"github.com/bosley/nerv-go"
 	"io/ioutil"
 	"log/slog"
+	"net"
 	"net/http"
-	"os"
 	"sync"
 	"time"
 )
@@ -25,6 +25,18 @@ const (
 	endpointPing     = "/ping"
 	endpointSubmit   = "/submit"
 	endpointPingResp = "Кто там?"
+
+	clientLimiterSweep = time.Minute
+)
+
+const (
+	// Body of a successful submission response when the event was
+	// dropped because its Id was already submitted recently
+	ResponseDuplicate = "duplicate"
+
+	// Body of a successful submission response when the event was
+	// dropped because the client exceeded its rate limit
+	ResponseDropped = "dropped"
 )
 
 var ErrServerAlreadyRunning = errors.New("server already running")
@@ -49,11 +61,19 @@ type SubmissionResponse struct {
 // a proper nerv event for publishing
 type Endpoint struct {
 	wg               *sync.WaitGroup
-	engine           *nerv.Engine
+	address          string
 	server           *http.Server
+	serveErr         error
+	serveMu          sync.Mutex
 	shutdownDuration time.Duration
 	authCb           AuthCb
+	identityCb       IdentityCb
 	pane             *nerv.ModulePane
+	topics           []*nerv.TopicCfg
+	clientLimit      *nerv.RateLimit
+	clientLimiters   map[string]*nerv.RateLimiter
+	limitSwept       time.Time
+	limitMu          sync.Mutex
 }
 
 // Within RequestEventSubmission, we optionally add Auth
@@ -62,16 +82,33 @@ type Endpoint struct {
 // then a simple T/F return dictates if the request is ok
 type AuthCb func(request *RequestEventSubmission) bool
 
+// Resolves the producer that a request is permitted to submit as. The
+// event is submitted as that producer whatever producer it names, so
+// that the engine holds it to the topics the producer may publish to.
+// Requests for which ok is false are refused
+type IdentityCb func(request *RequestEventSubmission) (producer string, ok bool)
+
 type Config struct {
 	Address                  string
 	GracefulShutdownDuration time.Duration
 	AuthCb                   AuthCb
+	IdentityCb               IdentityCb
+
+	// Optional limit applied to each remote client (by host)
+	// independently of any engine-side limits
+	ClientRateLimit *nerv.RateLimit
+
+	// Topics the module creates for itself when it starts, if they
+	// don't exist already, so that submissions have somewhere to go
+	Topics []*nerv.TopicCfg
 }
 
 // Submit an event with the optional Auth interface. Auth will be encoded into JSON
 // with the rest of the message. The server, detecting Auth, will execute server-side
-// callback to have the information analyzed, and conditionally, permit the event submission
+// callback to have the information analyzed, and conditionally, permit the event submission.
+// If the event has no Id one is assigned so that retrying with the same event is idempotent
 func SubmitEventWithAuth(address string, event *nerv.Event, auth interface{}) (*SubmissionResponse, error) {
+	assignId(event)
 	out := RequestEventSubmission{
 		Auth:  auth,
 		Event: *event,
@@ -83,8 +120,10 @@ func SubmitEventWithAuth(address string, event *nerv.Event, auth interface{}) (*
 	return send(fmtEndpoint(address, endpointSubmit), encoded)
 }
 
-// Submit an event without Auth information
+// Submit an event without Auth information. If the event has no Id one is
+// assigned so that retrying with the same event is idempotent
 func SubmitEvent(address string, event *nerv.Event) (*SubmissionResponse, error) {
+	assignId(event)
 	out := RequestEventSubmission{
 		Event: *event,
 	}
@@ -121,14 +160,18 @@ func SubmitPing(address string, count int, max_failures int) PingResponse {
 
 // Create the endpoint structure that will be used as the nerv Module
 // interface
-func New(cfg Config, engine *nerv.Engine) *Endpoint {
+func New(cfg Config) *Endpoint {
 	return &Endpoint{
 		wg:               nil,
-		engine:           engine,
-		server:           &http.Server{Addr: cfg.Address},
+		topics:           cfg.Topics,
+		address:          cfg.Address,
+		server:           nil,
 		shutdownDuration: cfg.GracefulShutdownDuration,
 		authCb:           cfg.AuthCb,
+		identityCb:       cfg.IdentityCb,
 		pane:             nil,
+		clientLimit:      cfg.ClientRateLimit,
+		clientLimiters:   make(map[string]*nerv.RateLimiter),
 	}
 }
 
@@ -146,33 +189,65 @@ func (ep *Endpoint) GetName() string {
 // Module interface requirement - Obvious functionality
 func (ep *Endpoint) Start() error {
 
-	slog.Info("modhttp:start")
+	ep.pane.Logger.Info("modhttp:start")
 
 	if ep.wg != nil {
 		return ErrServerAlreadyRunning
 	}
 
-	http.HandleFunc(endpointSubmit, ep.handleSubmission())
-	http.HandleFunc(endpointPing, ep.handlePing())
+	for _, topic := range ep.topics {
+		if err := ep.pane.CreateTopic(topic); err != nil && !errors.Is(err, nerv.ErrEngineDuplicateTopic) {
+			return err
+		}
+	}
+
+	listener, err := net.Listen("tcp", ep.address)
+	if err != nil {
+		ep.pane.Logger.Error("error starting http - port already in use?", "err", err.Error())
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(endpointSubmit, ep.handleSubmission())
+	mux.HandleFunc(endpointPing, ep.handlePing())
+
+	ep.server = &http.Server{
+		Addr:    ep.address,
+		Handler: mux,
+	}
+
+	ep.serveMu.Lock()
+	ep.serveErr = nil
+	ep.serveMu.Unlock()
 
 	ep.wg = new(sync.WaitGroup)
 	ep.wg.Add(1)
 
 	go func() {
 		defer ep.wg.Done()
-		if err := ep.server.ListenAndServe(); err != http.ErrServerClosed {
-			slog.Error("error starting http - port already in use?")
-			os.Exit(1)
+		if err := ep.server.Serve(listener); err != http.ErrServerClosed {
+			ep.pane.Logger.Error("http server stopped serving", "err", err.Error())
+			ep.serveMu.Lock()
+			ep.serveErr = err
+			ep.serveMu.Unlock()
 		}
 	}()
 
 	return nil
 }
 
+// HealthChecker interface - Unhealthy once the server
+// stops serving for any reason other than shutdown
+func (ep *Endpoint) Health() error {
+	ep.serveMu.Lock()
+	defer ep.serveMu.Unlock()
+	return ep.serveErr
+}
+
 // Module interface requirement - Obvious functionality
 func (ep *Endpoint) Shutdown() {
 
-	slog.Info("modhttp:shutdown")
+	ep.pane.Logger.Info("modhttp:shutdown")
 
 	if ep.wg == nil {
 		return
@@ -185,7 +260,7 @@ func (ep *Endpoint) Shutdown() {
 	defer shutdownRelease()
 
 	if err := ep.server.Shutdown(shutdownCtx); err != nil {
-		panic(err)
+		ep.pane.Logger.Error("modhttp:shutdown", "err", err.Error())
 	}
 
 	ep.wg.Wait()
@@ -196,7 +271,7 @@ func (ep *Endpoint) Shutdown() {
 func (ep *Endpoint) handlePing() func(http.ResponseWriter, *http.Request) {
 	return func(writer http.ResponseWriter, req *http.Request) {
 
-		slog.Debug("modhttp:ping")
+		ep.pane.Logger.Debug("modhttp:ping")
 
 		writer.WriteHeader(200)
 		writer.Write([]byte(endpointPingResp))
@@ -208,52 +283,143 @@ func (ep *Endpoint) handleSubmission() func(http.ResponseWriter, *http.Request)
 
 		if ep.pane == nil {
 			writer.WriteHeader(503)
+			return
 		}
 
 		body, err := ioutil.ReadAll(req.Body)
 
 		if err != nil {
-			slog.Error("modhttp:handleSubmission", "err", err.Error())
+			ep.pane.Logger.Error("modhttp:handleSubmission", "err", err.Error())
 			writer.WriteHeader(400)
 			return
 		}
 
-		slog.Debug("modhttp:handleSubmission", "body", string(body))
-
 		var reqWrapper RequestEventSubmission
 
 		if err := json.Unmarshal(body, &reqWrapper); err != nil {
+			ep.pane.Logger.Debug("modhttp:handleSubmission malformed", "bytes", len(body))
 			writer.WriteHeader(400)
 			return
 		}
 
 		event := reqWrapper.Event
 
+		// Bodies carry auth and payloads, so only what
+		// the engine permits of the payload is logged
+		ep.pane.Logger.Debug("modhttp:handleSubmission",
+			"topic", event.Topic,
+			"producer", event.Producer,
+			"data", ep.pane.Redact(&event))
+
 		if ep.authCb != nil {
 			auth := reqWrapper.Auth
 			if auth == nil {
-				slog.Warn("event submission rejection - missing auth", "topic", event.Topic, "producer", event.Producer)
+				ep.pane.Logger.Warn("event submission rejection - missing auth", "topic", event.Topic, "producer", event.Producer)
 				writer.WriteHeader(401)
+				return
 			}
 			if !ep.authCb(&reqWrapper) {
-				slog.Warn("event submission auth failure", "topic", event.Topic, "producer", event.Producer)
+				ep.pane.Logger.Warn("event submission auth failure", "topic", event.Topic, "producer", event.Producer)
+				writer.WriteHeader(401)
+				return
+			}
+		}
+
+		if ep.identityCb != nil {
+			producer, ok := ep.identityCb(&reqWrapper)
+			if !ok {
+				ep.pane.Logger.Warn("event submission without identity", "topic", event.Topic, "producer", event.Producer)
 				writer.WriteHeader(401)
+				return
 			}
+			event.Producer = producer
 		}
 
-		if !ep.engine.ContainsTopic(&event.Topic) {
+		if !ep.pane.ContainsTopic(event.Topic) {
 			writer.WriteHeader(400)
 			writer.Write([]byte("unknown topic"))
 			return
 		}
 
-		ep.pane.SubmitEvent(&event)
+		if limiter := ep.limiterFor(req); limiter != nil && !limiter.Admit() {
+			ep.pane.Logger.Warn("event submission over client rate limit", "remote", req.RemoteAddr, "topic", event.Topic)
+			if limiter.Policy() == nerv.RateLimitReject {
+				writer.WriteHeader(429)
+				return
+			}
+			writer.WriteHeader(200)
+			writer.Write([]byte(ResponseDropped))
+			return
+		}
+
+		if err := ep.pane.SubmitEvent(&event); err != nil {
+			if errors.Is(err, nerv.ErrEngineDuplicateEvent) {
+				ep.pane.Logger.Debug("modhttp:handleSubmission duplicate", "topic", event.Topic, "id", event.Id)
+				writer.WriteHeader(200)
+				writer.Write([]byte(ResponseDuplicate))
+				return
+			}
+			if errors.Is(err, nerv.ErrEngineRateLimited) {
+				writer.WriteHeader(429)
+				return
+			}
+			if errors.Is(err, nerv.ErrEnginePublishDenied) || errors.Is(err, nerv.ErrEngineUnknownProducer) {
+				ep.pane.Logger.Warn("event submission not permitted", "topic", event.Topic, "producer", event.Producer)
+				writer.WriteHeader(403)
+				return
+			}
+			writer.WriteHeader(503)
+			writer.Write([]byte(err.Error()))
+			return
+		}
 
 		writer.WriteHeader(200)
 		return
 	}
 }
 
+// Retrieve the limiter for the client that made the request,
+// or nil if clients are not limited
+func (ep *Endpoint) limiterFor(req *http.Request) *nerv.RateLimiter {
+
+	if ep.clientLimit == nil {
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	ep.limitMu.Lock()
+	defer ep.limitMu.Unlock()
+
+	// Clients whose buckets have refilled are forgotten, as a new
+	// limiter would treat them no differently, so that the set of
+	// limiters doesn't grow with every client ever seen
+	if time.Since(ep.limitSwept) >= clientLimiterSweep {
+		for client, limiter := range ep.clientLimiters {
+			if limiter.Idle() {
+				delete(ep.clientLimiters, client)
+			}
+		}
+		ep.limitSwept = time.Now()
+	}
+
+	limiter, ok := ep.clientLimiters[host]
+	if !ok {
+		limiter = nerv.NewRateLimiter(*ep.clientLimit)
+		ep.clientLimiters[host] = limiter
+	}
+	return limiter
+}
+
+func assignId(event *nerv.Event) {
+	if len(event.Id) == 0 {
+		event.Id = nerv.NewEventId()
+	}
+}
+
 func fmtEndpoint(address string, endpoint string) string {
 	return fmt.Sprintf("%s%s%s", protocolString, address, endpoint)
 }
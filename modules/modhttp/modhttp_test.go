@@ -1,9 +1,12 @@
 package modhttp
 
 import (
+	"errors"
 	"fmt"
 	"github.com/bosley/nerv-go"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 	"time"
@@ -40,7 +43,7 @@ func TestServer(t *testing.T) {
 			AuthCb: func(req *RequestEventSubmission) bool {
 				slog.Debug("http auth callback", "topic", req.Event.Topic, "prod", req.Event.Producer)
 				return req.Auth.(string) == testApiToken
-			}}, engine)
+			}})
 
 	topic := nerv.NewTopic(topicName).
 		UsingBroadcast().
@@ -100,3 +103,302 @@ func TestServer(t *testing.T) {
 		t.Fatal("Consumer A did not recv HTTP data")
 	}
 }
+
+func TestServerDuplicates(t *testing.T) {
+
+	address := "127.0.0.1:20005"
+	topicName := "module.http.dedup"
+
+	engine := nerv.NewEngine()
+
+	mod := New(
+		Config{
+			Address:                  address,
+			GracefulShutdownDuration: time.Second,
+		})
+
+	if err := engine.UseModule(mod, []*nerv.TopicCfg{
+		nerv.NewTopic(topicName).UsingDeduplication(time.Minute),
+	}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer engine.Stop()
+
+	event := &nerv.Event{
+		Spawned:  time.Now(),
+		Topic:    topicName,
+		Producer: "http.client",
+		Id:       nerv.NewEventId(),
+		Data:     "some simple test data",
+	}
+
+	sender := func() *SubmissionResponse {
+		resp, err := SubmitEvent(address, event)
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return resp
+	}
+
+	if resp := sender(); resp.Body == ResponseDuplicate {
+		t.Fatal("first submission reported as duplicate")
+	}
+
+	// Retrying the same event should be dropped by the engine
+	if resp := sender(); resp.Body != ResponseDuplicate {
+		t.Fatalf("expected retry to be reported as duplicate, got %s", resp.Body)
+	}
+}
+
+func TestServerAddressInUse(t *testing.T) {
+
+	cfg := Config{
+		Address:                  "127.0.0.1:20002",
+		GracefulShutdownDuration: time.Second,
+		Topics:                   []*nerv.TopicCfg{nerv.NewTopic("provisioned")},
+	}
+
+	first := nerv.NewEngine()
+	first.UseModule(New(cfg), []*nerv.TopicCfg{})
+
+	if err := first.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	defer first.Stop()
+
+	provisioned := "provisioned"
+	if !first.ContainsTopic(&provisioned) {
+		t.Fatal("expected module to provision its topics")
+	}
+
+	second := nerv.NewEngine()
+	second.UseModule(New(cfg), []*nerv.TopicCfg{})
+
+	if err := second.Start(); err == nil {
+		t.Fatal("expected start to fail with address in use")
+	}
+
+	if err := second.Stop(); err != nerv.ErrEngineNotRunning {
+		t.Fatalf("expected engine to be left stopped, got %v", err)
+	}
+}
+
+func TestFactory(t *testing.T) {
+
+	if _, err := Factory([]byte(`{"grace": "1s"}`)); !errors.Is(err, ErrInvalidSettings) {
+		t.Fatalf("expected missing address to be invalid, got %v", err)
+	}
+
+	if _, err := Factory([]byte(`{"address": "127.0.0.1:20003", "auth": {"mode": "magic"}}`)); !errors.Is(err, ErrInvalidSettings) {
+		t.Fatalf("expected unknown auth mode to be invalid, got %v", err)
+	}
+
+	if _, err := Factory([]byte(`{"address": "127.0.0.1:20003", "grase": "1s"}`)); !errors.Is(err, ErrInvalidSettings) {
+		t.Fatalf("expected unknown setting to be invalid, got %v", err)
+	}
+
+	mod, err := nerv.NewModule(FactoryKind, []byte(`{
+		"address": "127.0.0.1:20003",
+		"grace": "1s",
+		"auth": {"mode": "token", "tokens": ["let-me-in"]}
+	}`))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	ep := mod.(*Endpoint)
+
+	if ep.address != "127.0.0.1:20003" || ep.shutdownDuration != time.Second {
+		t.Fatalf("settings not applied: %+v", ep)
+	}
+
+	if !ep.authCb(&RequestEventSubmission{Auth: "let-me-in"}) || ep.authCb(&RequestEventSubmission{Auth: "nope"}) {
+		t.Fatal("token auth not applied")
+	}
+}
+
+func TestServerIdentities(t *testing.T) {
+
+	address := "127.0.0.1:20004"
+
+	engine := nerv.NewEngine().WithUnknownProducers(false)
+
+	for _, topic := range []string{"orders", "billing"} {
+		if err := engine.CreateTopic(nerv.NewTopic(topic)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	if err := engine.RegisterProducer(nerv.NewProducer("shop").PermitTopics("orders")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	recvd := make(chan *nerv.Event, 1)
+	engine.Register(nerv.Consumer{
+		Id: "orders.reader",
+		Fn: func(event *nerv.Event) {
+			recvd <- event
+		},
+	})
+	engine.SubscribeTo("orders", "orders.reader")
+
+	mod, err := Factory([]byte(fmt.Sprintf(`{
+		"address": "%s",
+		"grace": "1s",
+		"auth": {"mode": "token", "tokens": ["anonymous"], "identities": {"shop-token": "shop"}}
+	}`, address)))
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.UseModule(mod, []*nerv.TopicCfg{}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer engine.Stop()
+
+	submit := func(topic string, producer string, auth interface{}) string {
+		event := &nerv.Event{
+			Spawned:  time.Now(),
+			Topic:    topic,
+			Producer: producer,
+		}
+		var resp *SubmissionResponse
+		var err error
+		if auth == nil {
+			resp, err = SubmitEvent(address, event)
+		} else {
+			resp, err = SubmitEventWithAuth(address, event, auth)
+		}
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+		return resp.Status
+	}
+
+	for _, tc := range []struct {
+		topic    string
+		producer string
+		auth     interface{}
+		status   string
+	}{
+		{"orders", "impostor", nil, "401 Unauthorized"},
+		{"orders", "impostor", "wrong-token", "401 Unauthorized"},
+		{"orders", "impostor", "anonymous", "403 Forbidden"},
+
+		// Tokens without an identity can't claim someone else's
+		{"orders", "shop", "anonymous", "403 Forbidden"},
+
+		{"billing", "impostor", "shop-token", "403 Forbidden"},
+		{"orders", "impostor", "shop-token", "200 OK"},
+	} {
+		if status := submit(tc.topic, tc.producer, tc.auth); status != tc.status {
+			t.Fatalf("expected %s submitting to %s with %v, got %s", tc.status, tc.topic, tc.auth, status)
+		}
+	}
+
+	if event := <-recvd; event.Producer != "shop" {
+		t.Fatalf("expected event to be submitted as the token's identity, got %s", event.Producer)
+	}
+}
+
+func TestClientLimiterSweep(t *testing.T) {
+
+	ep := New(Config{
+		Address: "127.0.0.1:20006",
+		ClientRateLimit: &nerv.RateLimit{
+			Rate:   1000,
+			Burst:  1,
+			Policy: nerv.RateLimitReject,
+		},
+	})
+
+	request := func(client int) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, endpointSubmit, nil)
+		req.RemoteAddr = fmt.Sprintf("10.0.0.%d:4000", client)
+		return req
+	}
+
+	for client := 0; client < 8; client++ {
+		ep.limiterFor(request(client)).Admit()
+	}
+
+	if len(ep.clientLimiters) != 8 {
+		t.Fatalf("expected a limiter per client, got %d", len(ep.clientLimiters))
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	// Once refilled, limiters are forgotten on the next sweep
+	ep.limitSwept = time.Time{}
+	ep.limiterFor(request(8))
+
+	if len(ep.clientLimiters) != 1 {
+		t.Fatalf("expected idle limiters to be swept, got %d", len(ep.clientLimiters))
+	}
+}
+
+func TestServerAlias(t *testing.T) {
+
+	address := "127.0.0.1:20007"
+
+	engine := nerv.NewEngine()
+
+	mod := New(
+		Config{
+			Address:                  address,
+			GracefulShutdownDuration: time.Second,
+		})
+
+	if err := engine.UseModule(mod, []*nerv.TopicCfg{nerv.NewTopic("orders.v2")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.AddTopicAlias("orders", "orders.v2"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	recvd := make(chan *nerv.Event, 1)
+	engine.Register(nerv.Consumer{
+		Id: "orders.reader",
+		Fn: func(event *nerv.Event) {
+			recvd <- event
+		},
+	})
+	engine.SubscribeTo("orders.v2", "orders.reader")
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	defer engine.Stop()
+
+	resp, err := SubmitEvent(address, &nerv.Event{
+		Spawned:  time.Now(),
+		Topic:    "orders",
+		Producer: "http.client",
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if resp.Status != "200 OK" {
+		t.Fatalf("expected submission to an alias to be accepted, got %s", resp.Status)
+	}
+
+	select {
+	case event := <-recvd:
+		if event.Topic != "orders.v2" {
+			t.Fatalf("expected event on the aliased topic, got %s", event.Topic)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("submission to an alias was not delivered")
+	}
+}
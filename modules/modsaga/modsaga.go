@@ -0,0 +1,508 @@
+/*
+  This module orchestrates long-running workflows (sagas) over the
+  event bus. Each step of a workflow publishes a command and awaits a
+  reply. If a step fails after its retries are exhausted, the
+  compensations of every completed step are published in reverse.
+*/
+
+package modsaga
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/bosley/nerv-go"
+	"sync"
+	"time"
+)
+
+const (
+	defaultName = "nerv.mod.saga"
+)
+
+const (
+	InstanceRunning = iota
+	InstanceCompleted
+	InstanceCompensated
+
+	// Persisted instances whose workflow no longer has the step
+	// they were at, which are never resumed
+	InstanceFailed
+)
+
+var ErrInvalidWorkflow = errors.New("workflow requires a name and steps with command and reply topics")
+var ErrDuplicateWorkflow = errors.New("duplicate workflow")
+var ErrUnknownWorkflow = errors.New("unknown workflow")
+var ErrNotRunning = errors.New("saga module not running")
+
+// A single step of a workflow. Command is published on the Command topic
+// and a Reply is expected on the Reply topic within Timeout. A step that
+// fails or times out is retried up to Retries times. When a later step
+// fails the Compensation topic, if given, is sent a command to undo this step
+type Step struct {
+	Name         string
+	Command      string
+	Reply        string
+	Compensation string
+	Timeout      time.Duration
+	Retries      int
+}
+
+type Workflow struct {
+	Name  string
+	Steps []Step
+}
+
+// Data of the events published on command and compensation topics
+type Command struct {
+	InstanceId string
+	Workflow   string
+	Step       string
+	Attempt    int
+	Data       interface{}
+}
+
+// Data expected on the reply topic of a step. Attempt must be that of the
+// command being answered, so late replies to earlier attempts are ignored
+type Reply struct {
+	InstanceId string
+	Attempt    int
+	Ok         bool
+	Data       interface{}
+	Error      string
+}
+
+// State of a single execution of a workflow. Data is handed to the
+// commands of every step, and the data of each successful reply is
+// recorded in Results under the name of its step
+type Instance struct {
+	Id       string
+	Workflow string
+	State    int
+	Step     int
+	Attempt  int
+	Data     interface{}
+	Results  map[string]interface{}
+	Error    string
+	Updated  time.Time
+}
+
+func (i *Instance) clone() *Instance {
+	c := *i
+	c.Results = make(map[string]interface{}, len(i.Results))
+	for k, v := range i.Results {
+		c.Results[k] = v
+	}
+	return &c
+}
+
+// Module orchestrating the workflows
+type Module struct {
+	name      string
+	pane      *nerv.ModulePane
+	store     Store
+	workflows map[string]*Workflow
+	instances map[string]*Instance
+	timers    map[string]*time.Timer
+	running   bool
+	mu        sync.Mutex
+}
+
+// Create a saga module persisting instances to the given store. If
+// name is empty a default is used, and if store is nil instances are
+// only kept in memory
+func New(name string, store Store) *Module {
+	if len(name) == 0 {
+		name = defaultName
+	}
+	if store == nil {
+		store = NewMemoryStore()
+	}
+	return &Module{
+		name:      name,
+		pane:      nil,
+		store:     store,
+		workflows: make(map[string]*Workflow),
+		instances: make(map[string]*Instance),
+		timers:    make(map[string]*time.Timer),
+	}
+}
+
+// Topic that a snapshot of an instance is published on
+// whenever it completes or is compensated
+func (m *Module) StatusTopic() string {
+	return fmt.Sprintf("%s.status", m.name)
+}
+
+// Define a workflow that instances can then be started for
+func (m *Module) Define(wf Workflow) error {
+
+	if len(wf.Name) == 0 || len(wf.Steps) == 0 {
+		return ErrInvalidWorkflow
+	}
+
+	for _, step := range wf.Steps {
+		if len(step.Command) == 0 || len(step.Reply) == 0 {
+			return ErrInvalidWorkflow
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.workflows[wf.Name]; ok {
+		return ErrDuplicateWorkflow
+	}
+
+	m.workflows[wf.Name] = &wf
+	return nil
+}
+
+// Configuration of every command, reply and compensation topic of the
+// defined workflows along with the status topic, suitable for handing
+// to Engine.UseModule
+func (m *Module) Topics() []*nerv.TopicCfg {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := []string{m.StatusTopic()}
+	for _, wf := range m.workflows {
+		for _, step := range wf.Steps {
+			names = append(names, step.Command, step.Reply)
+			if len(step.Compensation) > 0 {
+				names = append(names, step.Compensation)
+			}
+		}
+	}
+
+	topics := make([]*nerv.TopicCfg, 0, len(names))
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		topics = append(topics, nerv.NewTopic(name))
+	}
+	return topics
+}
+
+// Start a new instance of a workflow, returning its id
+func (m *Module) Begin(workflow string, data interface{}) (string, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.running {
+		return "", ErrNotRunning
+	}
+
+	if _, ok := m.workflows[workflow]; !ok {
+		return "", ErrUnknownWorkflow
+	}
+
+	instance := &Instance{
+		Id:       nerv.NewEventId(),
+		Workflow: workflow,
+		State:    InstanceRunning,
+		Step:     0,
+		Attempt:  1,
+		Data:     data,
+		Results:  make(map[string]interface{}),
+	}
+
+	m.instances[instance.Id] = instance
+
+	m.pane.Logger.Debug("modsaga:begin", "workflow", workflow, "instance", instance.Id)
+
+	if err := m.save(instance); err != nil {
+		delete(m.instances, instance.Id)
+		return "", err
+	}
+
+	m.sendCommand(instance)
+	return instance.Id, nil
+}
+
+// Retrieve a snapshot of an instance
+func (m *Module) Instance(id string) (*Instance, error) {
+	return m.store.Load(id)
+}
+
+func (m *Module) GetName() string {
+	return m.name
+}
+
+func (m *Module) RecvModulePane(p *nerv.ModulePane) {
+	if m.pane != nil {
+		return
+	}
+	m.pane = p
+}
+
+// Subscribes to the reply topics of every workflow
+// and resumes instances that were still running
+func (m *Module) Start() error {
+
+	if m.pane == nil {
+		return errors.New("no module pane for saga. did Start() run before module registration?")
+	}
+
+	m.pane.Logger.Info("modsaga:start", "name", m.name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	replies := make(map[string]bool)
+	for _, wf := range m.workflows {
+		for _, step := range wf.Steps {
+			replies[step.Reply] = true
+		}
+	}
+
+	for topic := range replies {
+		consumer := nerv.Consumer{
+			Id: fmt.Sprintf("%s:%s", m.name, topic),
+			Fn: m.handleReply,
+		}
+		if err := m.pane.SubscribeTo(topic, []nerv.Consumer{consumer}, true); err != nil {
+			return err
+		}
+	}
+
+	m.running = true
+
+	persisted, err := m.store.List()
+	if err != nil {
+		return err
+	}
+
+	for _, instance := range persisted {
+		if instance.State != InstanceRunning {
+			continue
+		}
+		if _, ok := m.workflows[instance.Workflow]; !ok {
+			m.pane.Logger.Warn("modsaga:resume unknown workflow", "workflow", instance.Workflow, "instance", instance.Id)
+			continue
+		}
+		if steps := len(m.workflows[instance.Workflow].Steps); instance.Step < 0 || instance.Step >= steps {
+			m.pane.Logger.Warn("modsaga:resume unknown step", "workflow", instance.Workflow, "instance", instance.Id, "step", instance.Step)
+			instance.State = InstanceFailed
+			instance.Error = fmt.Sprintf("workflow %s has no step %d", instance.Workflow, instance.Step)
+			m.finish(instance)
+			continue
+		}
+		m.pane.Logger.Debug("modsaga:resume", "workflow", instance.Workflow, "instance", instance.Id)
+		m.instances[instance.Id] = instance
+		m.sendCommand(instance)
+	}
+	return nil
+}
+
+// Stops all step timers. Running instances remain
+// in the store and are resumed on next start
+func (m *Module) Shutdown() {
+
+	m.pane.Logger.Info("modsaga:shutdown", "name", m.name)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.running = false
+	for id, timer := range m.timers {
+		timer.Stop()
+		delete(m.timers, id)
+	}
+	m.instances = make(map[string]*Instance)
+}
+
+// Expects m.mu to be held
+func (m *Module) save(instance *Instance) error {
+	instance.Updated = time.Now()
+	return m.store.Save(instance)
+}
+
+// Publish the command of the instance's current step and arm
+// its timeout. Expects m.mu to be held
+func (m *Module) sendCommand(instance *Instance) {
+
+	step := m.workflows[instance.Workflow].Steps[instance.Step]
+
+	if step.Timeout > 0 {
+		if timer, ok := m.timers[instance.Id]; ok {
+			timer.Stop()
+		}
+		attempt := instance.Attempt
+		m.timers[instance.Id] = time.AfterFunc(step.Timeout, func() {
+			m.handleTimeout(instance.Id, step.Name, attempt)
+		})
+	}
+
+	m.pane.SubmitTo(step.Command, &Command{
+		InstanceId: instance.Id,
+		Workflow:   instance.Workflow,
+		Step:       step.Name,
+		Attempt:    instance.Attempt,
+		Data:       instance.Data,
+	})
+}
+
+func (m *Module) handleTimeout(id string, stepName string, attempt int) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	instance, ok := m.instances[id]
+	if !ok || instance.State != InstanceRunning || instance.Attempt != attempt {
+		return
+	}
+
+	step := m.workflows[instance.Workflow].Steps[instance.Step]
+	if step.Name != stepName {
+		return
+	}
+
+	m.pane.Logger.Warn("modsaga:step timeout", "instance", id, "step", step.Name, "attempt", attempt)
+	m.fail(instance, fmt.Sprintf("step %s timed out", step.Name))
+}
+
+func (m *Module) handleReply(event *nerv.Event) {
+
+	reply, err := decodeReply(event.Data)
+	if err != nil {
+		m.pane.Logger.Warn("modsaga:invalid reply", "topic", event.Topic, "producer", event.Producer, "err", err.Error())
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	instance, ok := m.instances[reply.InstanceId]
+	if !ok || instance.State != InstanceRunning {
+		m.pane.Logger.Debug("modsaga:reply for inactive instance", "instance", reply.InstanceId)
+		return
+	}
+
+	wf := m.workflows[instance.Workflow]
+	step := wf.Steps[instance.Step]
+	if step.Reply != event.Topic {
+		m.pane.Logger.Debug("modsaga:reply not expected", "instance", instance.Id, "topic", event.Topic)
+		return
+	}
+
+	if reply.Attempt != instance.Attempt {
+		m.pane.Logger.Debug("modsaga:reply for stale attempt", "instance", instance.Id, "attempt", reply.Attempt)
+		return
+	}
+
+	if timer, ok := m.timers[instance.Id]; ok {
+		timer.Stop()
+		delete(m.timers, instance.Id)
+	}
+
+	if !reply.Ok {
+		m.fail(instance, reply.Error)
+		return
+	}
+
+	instance.Results[step.Name] = reply.Data
+	instance.Step += 1
+	instance.Attempt = 1
+
+	if instance.Step >= len(wf.Steps) {
+		instance.State = InstanceCompleted
+		m.finish(instance)
+		return
+	}
+
+	if err := m.save(instance); err != nil {
+		m.pane.Logger.Error("modsaga:failed to persist instance", "instance", instance.Id, "err", err.Error())
+	}
+
+	m.sendCommand(instance)
+}
+
+// Retry the current step if it has attempts left, otherwise compensate
+// every step that completed, most recent first. Expects m.mu to be held
+func (m *Module) fail(instance *Instance, reason string) {
+
+	wf := m.workflows[instance.Workflow]
+	step := wf.Steps[instance.Step]
+
+	if instance.Attempt <= step.Retries {
+		instance.Attempt += 1
+		m.pane.Logger.Debug("modsaga:retry", "instance", instance.Id, "step", step.Name, "attempt", instance.Attempt)
+		if err := m.save(instance); err != nil {
+			m.pane.Logger.Error("modsaga:failed to persist instance", "instance", instance.Id, "err", err.Error())
+		}
+		m.sendCommand(instance)
+		return
+	}
+
+	if timer, ok := m.timers[instance.Id]; ok {
+		timer.Stop()
+		delete(m.timers, instance.Id)
+	}
+
+	instance.Error = reason
+
+	for i := instance.Step - 1; i >= 0; i-- {
+		completed := wf.Steps[i]
+		if len(completed.Compensation) == 0 {
+			continue
+		}
+		m.pane.Logger.Debug("modsaga:compensate", "instance", instance.Id, "step", completed.Name)
+		m.pane.SubmitTo(completed.Compensation, &Command{
+			InstanceId: instance.Id,
+			Workflow:   instance.Workflow,
+			Step:       completed.Name,
+			Data:       instance.Results[completed.Name],
+		})
+	}
+
+	instance.State = InstanceCompensated
+	m.finish(instance)
+}
+
+// Expects m.mu to be held
+func (m *Module) finish(instance *Instance) {
+
+	m.pane.Logger.Debug("modsaga:finish", "instance", instance.Id, "state", instance.State)
+
+	if err := m.save(instance); err != nil {
+		m.pane.Logger.Error("modsaga:failed to persist instance", "instance", instance.Id, "err", err.Error())
+	}
+
+	delete(m.instances, instance.Id)
+	m.pane.SubmitTo(m.StatusTopic(), instance.clone())
+}
+
+// Replies submitted in-process carry a Reply, while those that
+// arrived over the wire have been decoded into generic json
+func decodeReply(data interface{}) (*Reply, error) {
+	switch reply := data.(type) {
+	case *Reply:
+		if reply == nil {
+			return nil, errors.New("reply is nil")
+		}
+		return reply, nil
+	case Reply:
+		return &reply, nil
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var reply Reply
+	if err := json.Unmarshal(raw, &reply); err != nil {
+		return nil, err
+	}
+
+	if len(reply.InstanceId) == 0 {
+		return nil, errors.New("reply is missing instance id")
+	}
+	return &reply, nil
+}
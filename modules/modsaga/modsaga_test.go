@@ -0,0 +1,316 @@
+package modsaga
+
+import (
+	"github.com/bosley/nerv-go"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeService struct {
+	engine *nerv.Engine
+	reply  string
+	fail   bool
+	silent int
+	recvd  int
+	undone []string
+	mu     sync.Mutex
+}
+
+func (s *fakeService) handleCommand(event *nerv.Event) {
+	cmd := event.Data.(*Command)
+
+	s.mu.Lock()
+	s.recvd += 1
+	silent := s.recvd <= s.silent
+	s.mu.Unlock()
+
+	if silent {
+		return
+	}
+
+	s.engine.Submit("service", s.reply, &Reply{
+		InstanceId: cmd.InstanceId,
+		Attempt:    cmd.Attempt,
+		Ok:         !s.fail,
+		Data:       cmd.Step + ":done",
+		Error:      "service refused",
+	})
+}
+
+func (s *fakeService) handleCompensation(event *nerv.Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.undone = append(s.undone, event.Data.(*Command).Step)
+}
+
+func orderWorkflow() Workflow {
+	return Workflow{
+		Name: "order",
+		Steps: []Step{
+			{
+				Name:         "reserve",
+				Command:      "inventory.reserve",
+				Reply:        "inventory.reserved",
+				Compensation: "inventory.release",
+				Timeout:      100 * time.Millisecond,
+			},
+			{
+				Name:         "charge",
+				Command:      "payment.charge",
+				Reply:        "payment.charged",
+				Compensation: "payment.refund",
+				Timeout:      100 * time.Millisecond,
+				Retries:      1,
+			},
+		},
+	}
+}
+
+func setupSaga(t *testing.T, store Store) (*nerv.Engine, *Module, *fakeService, *fakeService) {
+
+	engine := nerv.NewEngine()
+
+	mod := New("", store)
+
+	if err := mod.Define(Workflow{Name: "empty"}); err != ErrInvalidWorkflow {
+		t.Fatalf("expected invalid workflow, got %v", err)
+	}
+
+	if err := mod.Define(orderWorkflow()); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	engine.UseModule(mod, mod.Topics())
+
+	inventory := &fakeService{engine: engine, reply: "inventory.reserved"}
+	payment := &fakeService{engine: engine, reply: "payment.charged"}
+
+	engine.Register(nerv.Consumer{Id: "inventory", Fn: inventory.handleCommand})
+	engine.Register(nerv.Consumer{Id: "inventory.undo", Fn: inventory.handleCompensation})
+	engine.Register(nerv.Consumer{Id: "payment", Fn: payment.handleCommand})
+	engine.Register(nerv.Consumer{Id: "payment.undo", Fn: payment.handleCompensation})
+
+	for topic, consumer := range map[string]string{
+		"inventory.reserve": "inventory",
+		"inventory.release": "inventory.undo",
+		"payment.charge":    "payment",
+		"payment.refund":    "payment.undo",
+	} {
+		if err := engine.SubscribeTo(topic, consumer); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	return engine, mod, inventory, payment
+}
+
+func TestSagaCompletes(t *testing.T) {
+
+	engine, mod, _, payment := setupSaga(t, nil)
+
+	// First charge attempt goes unanswered and is retried
+	payment.silent = 1
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	id, err := mod.Begin("order", "order-1")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	instance, err := mod.Instance(id)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if instance.State != InstanceCompleted {
+		t.Fatalf("expected instance to complete, got state %d (%s)", instance.State, instance.Error)
+	}
+
+	if instance.Results["charge"] != "charge:done" || instance.Attempt != 1 {
+		t.Fatalf("unexpected instance %+v", instance)
+	}
+
+	if payment.recvd != 2 {
+		t.Fatalf("expected charge to be attempted twice, got %d", payment.recvd)
+	}
+}
+
+func TestSagaCompensates(t *testing.T) {
+
+	engine, mod, inventory, payment := setupSaga(t, nil)
+
+	payment.fail = true
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	id, err := mod.Begin("order", "order-2")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	instance, err := mod.Instance(id)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if instance.State != InstanceCompensated || instance.Error != "service refused" {
+		t.Fatalf("expected instance to be compensated, got %+v", instance)
+	}
+
+	if len(inventory.undone) != 1 || inventory.undone[0] != "reserve" {
+		t.Fatalf("expected reservation to be released, got %v", inventory.undone)
+	}
+
+	if len(payment.undone) != 0 {
+		t.Fatal("failed step should not be compensated")
+	}
+}
+
+func TestSagaResumes(t *testing.T) {
+
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := store.Save(&Instance{
+		Id:       "persisted",
+		Workflow: "order",
+		State:    InstanceRunning,
+		Step:     1,
+		Attempt:  1,
+		Data:     "order-3",
+		Results:  map[string]interface{}{"reserve": "reserve:done"},
+	}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	engine, mod, inventory, payment := setupSaga(t, store)
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	instance, err := mod.Instance("persisted")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if instance.State != InstanceCompleted {
+		t.Fatalf("expected resumed instance to complete, got %+v", instance)
+	}
+
+	if inventory.recvd != 0 || payment.recvd != 1 {
+		t.Fatalf("resumed instance should only run remaining steps, got %d/%d", inventory.recvd, payment.recvd)
+	}
+}
+
+func TestSagaIgnoresStaleReplies(t *testing.T) {
+
+	engine, mod, _, payment := setupSaga(t, nil)
+
+	// Neither charge attempt is answered in time
+	payment.silent = 2
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	id, err := mod.Begin("order", "order-4")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	// The first attempt answers after it has already been retried
+	engine.Submit("service", "payment.charged", &Reply{
+		InstanceId: id,
+		Attempt:    1,
+		Ok:         true,
+		Data:       "charge:late",
+	})
+
+	time.Sleep(200 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	instance, err := mod.Instance(id)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if instance.State != InstanceCompensated {
+		t.Fatalf("expected late reply to be ignored, got %+v", instance)
+	}
+}
+
+func TestSagaResumeMissingStep(t *testing.T) {
+
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Persisted before the workflow lost its later steps
+	if err := store.Save(&Instance{
+		Id:       "outdated",
+		Workflow: "order",
+		State:    InstanceRunning,
+		Step:     5,
+		Attempt:  1,
+		Results:  map[string]interface{}{},
+	}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	engine, mod, _, _ := setupSaga(t, store)
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	// Malformed replies are ignored rather than panicking the dispatcher
+	engine.Submit("service", "inventory.reserved", (*Reply)(nil))
+
+	time.Sleep(50 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	instance, err := mod.Instance("outdated")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if instance.State != InstanceFailed || len(instance.Error) == 0 {
+		t.Fatalf("expected instance to fail rather than resume, got %+v", instance)
+	}
+}
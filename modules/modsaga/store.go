@@ -0,0 +1,139 @@
+package modsaga
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var ErrUnknownInstance = errors.New("unknown workflow instance")
+
+// Persistence of workflow instances so that they survive restarts
+type Store interface {
+	Save(instance *Instance) error
+	Load(id string) (*Instance, error)
+	List() ([]*Instance, error)
+}
+
+type memoryStore struct {
+	instances map[string]Instance
+	mu        sync.Mutex
+}
+
+// Store that keeps instances in memory only
+func NewMemoryStore() Store {
+	return &memoryStore{
+		instances: make(map[string]Instance),
+	}
+}
+
+func (s *memoryStore) Save(instance *Instance) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.instances[instance.Id] = *instance.clone()
+	return nil
+}
+
+func (s *memoryStore) Load(id string) (*Instance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	instance, ok := s.instances[id]
+	if !ok {
+		return nil, ErrUnknownInstance
+	}
+	return instance.clone(), nil
+}
+
+func (s *memoryStore) List() ([]*Instance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	instances := make([]*Instance, 0, len(s.instances))
+	for _, instance := range s.instances {
+		instances = append(instances, instance.clone())
+	}
+	return instances, nil
+}
+
+type fileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// Store that writes each instance as a json file within dir. Instance
+// data is restored as whatever encoding/json decodes it into
+func NewFileStore(dir string) (Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &fileStore{
+		dir: dir,
+	}, nil
+}
+
+func (s *fileStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *fileStore) Save(instance *Instance) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	raw, err := json.Marshal(instance)
+	if err != nil {
+		return err
+	}
+
+	tmp := s.path(instance.Id) + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path(instance.Id))
+}
+
+func (s *fileStore) Load(id string) (*Instance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load(s.path(id))
+}
+
+func (s *fileStore) load(path string) (*Instance, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrUnknownInstance
+		}
+		return nil, err
+	}
+
+	var instance Instance
+	if err := json.Unmarshal(raw, &instance); err != nil {
+		return nil, err
+	}
+	return &instance, nil
+}
+
+func (s *fileStore) List() ([]*Instance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make([]*Instance, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		instance, err := s.load(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		instances = append(instances, instance)
+	}
+	return instances, nil
+}
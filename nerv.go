@@ -1,6 +1,8 @@
 package nerv
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"time"
 )
 
@@ -10,10 +12,34 @@ type EventRecvr func(event *Event)
 // Event structure that is pushed through the event engine and delivered
 // to the subscriber(s) of topics
 type Event struct {
-	Spawned  time.Time   `json:spawned`
-	Topic    string      `json:topic`
-	Producer string      `json:producer`
-	Data     interface{} `json:data`
+	Spawned  time.Time   `json:"spawned"`
+	Topic    string      `json:"topic"`
+	Producer string      `json:"producer"`
+	Data     interface{} `json:"data"`
+
+	// Optional identifier of the event. Topics configured with
+	// deduplication use it as an idempotency key
+	Id string `json:"id,omitempty"`
+
+	// Position of the event within its topic's log. Only
+	// assigned by the engine for topics that retain a log
+	Offset uint64 `json:"offset,omitempty"`
+
+	// Number of times the event was resubmitted in response to an
+	// earlier one, and the topics of those earlier events, oldest first
+	Hops  int      `json:"hops,omitempty"`
+	Trail []string `json:"trail,omitempty"`
+
+	// Topic the event was delivered on, so that handing
+	// it back to the engine counts as another hop
+	via string
+}
+
+// Generate a random identifier suitable for Event.Id
+func NewEventId() string {
+	raw := make([]byte, 16)
+	rand.Read(raw)
+	return hex.EncodeToString(raw)
 }
 
 // Generalized "producer" that can be set
@@ -0,0 +1,202 @@
+package nerv
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrEngineModuleNotPermitted = errors.New("not permitted for module")
+
+// Build the pane handed to a module. Everything done through the pane
+// is done as the module, and is limited to what the module owns
+func (eng *Engine) newModulePane(name string, entry *moduleMetaPair) *ModulePane {
+
+	owns := func(topic string) bool {
+		for _, owned := range entry.topics {
+			if owned == topic {
+				return true
+			}
+		}
+		return false
+	}
+
+	pane := &ModulePane{
+		GetModuleMeta: eng.GetModuleMeta,
+		SubmitEvent: func(event *Event) error {
+			return eng.SubmitEvent(*event)
+		},
+		SubmitTo: func(topic string, data interface{}) {
+			eng.Submit(
+				name,
+				topic,
+				data)
+		},
+		SubmitFrom: func(parent *Event, topic string, data interface{}) error {
+			return eng.SubmitFrom(parent, Event{
+				Spawned:  time.Now(),
+				Topic:    topic,
+				Producer: name,
+				Data:     data,
+			})
+		},
+		Logger: newModuleLogger(eng, name),
+		Redact: eng.Redact,
+	}
+
+	subscribed := func(topic string, consumer string) bool {
+		eng.modMu.Lock()
+		defer eng.modMu.Unlock()
+
+		for _, sub := range entry.subscriptions {
+			if sub.topic == topic && sub.consumer == consumer {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Modules restarted by the supervisor keep their subscriptions, so
+	// those already made through the pane are not made a second time
+	pane.SubscribeTo = func(topicName string, consumers []Consumer, performRegistration bool) error {
+		for _, consumer := range consumers {
+			if subscribed(topicName, consumer.Id) {
+				continue
+			}
+			if performRegistration {
+				eng.Register(consumer)
+			}
+			if err := eng.subscribeTo(topicName, consumer.Id); err != nil {
+				return err
+			}
+			eng.modMu.Lock()
+			entry.subscriptions = append(entry.subscriptions, moduleSubscription{
+				topic:      topicName,
+				consumer:   consumer.Id,
+				registered: performRegistration,
+			})
+			eng.modMu.Unlock()
+		}
+		return nil
+	}
+
+	pane.Unsubscribe = func(topic string, consumers ...string) error {
+		eng.modMu.Lock()
+		defer eng.modMu.Unlock()
+
+		for _, consumer := range consumers {
+			retained := entry.subscriptions[:0]
+			found := false
+			for _, sub := range entry.subscriptions {
+				if sub.topic == topic && sub.consumer == consumer {
+					found = true
+					continue
+				}
+				retained = append(retained, sub)
+			}
+			if !found {
+				return ErrEngineModuleNotPermitted
+			}
+			entry.subscriptions = retained
+
+			if err := eng.Unsubscribe(topic, consumer); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	pane.SubmitAfter = func(delay time.Duration, topic string, data interface{}) func() {
+		eng.modMu.Lock()
+		defer eng.modMu.Unlock()
+
+		// Nothing is scheduled for a module that has been removed
+		if entry.scheduled == nil {
+			return func() {}
+		}
+
+		var timer *time.Timer
+		timer = time.AfterFunc(delay, func() {
+			eng.modMu.Lock()
+			pending := entry.scheduled[timer]
+			delete(entry.scheduled, timer)
+			eng.modMu.Unlock()
+
+			// Cancelled, or the module was removed, as the timer fired
+			if !pending {
+				return
+			}
+
+			if err := eng.Submit(name, topic, data); err != nil {
+				pane.Logger.Debug("scheduled submission failed", "topic", topic, "err", err.Error())
+			}
+		})
+		entry.scheduled[timer] = true
+
+		return func() {
+			eng.modMu.Lock()
+			defer eng.modMu.Unlock()
+
+			timer.Stop()
+			delete(entry.scheduled, timer)
+		}
+	}
+
+	pane.CreateTopic = func(cfg *TopicCfg) error {
+		if err := eng.CreateTopic(cfg); err != nil {
+			return err
+		}
+		eng.modMu.Lock()
+		entry.topics = append(entry.topics, cfg.Name)
+		eng.modMu.Unlock()
+		return nil
+	}
+
+	pane.DeleteTopic = func(topic string) error {
+		eng.modMu.Lock()
+		defer eng.modMu.Unlock()
+
+		if !owns(topic) {
+			return ErrEngineModuleNotPermitted
+		}
+
+		retained := entry.topics[:0]
+		for _, owned := range entry.topics {
+			if owned != topic {
+				retained = append(retained, owned)
+			}
+		}
+		entry.topics = retained
+
+		return eng.DeleteTopicWith(topic, DeleteReject)
+	}
+
+	pane.SetModuleMeta = func(data interface{}) error {
+		eng.modMu.Lock()
+		defer eng.modMu.Unlock()
+
+		entry.meta = data
+		return nil
+	}
+
+	pane.ContainsTopic = func(topic string) bool {
+		resolved := eng.ResolveTopic(topic)
+
+		eng.topicMu.Lock()
+		defer eng.topicMu.Unlock()
+
+		_, ok := eng.topics[resolved]
+		return ok
+	}
+
+	pane.ContainsConsumer = func(id string) bool {
+		eng.subMu.Lock()
+		defer eng.subMu.Unlock()
+
+		_, ok := eng.consumers[id]
+		return ok
+	}
+
+	pane.IsRunning = eng.running.Load
+
+	return pane
+}
@@ -0,0 +1,202 @@
+package nerv
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type paneModule struct {
+	pane *ModulePane
+}
+
+func (m *paneModule) GetName() string {
+	return "pane.module"
+}
+
+func (m *paneModule) RecvModulePane(pane *ModulePane) {
+	m.pane = pane
+}
+
+func (m *paneModule) Start() error {
+	return nil
+}
+
+func (m *paneModule) Shutdown() {}
+
+func TestModulePane(t *testing.T) {
+
+	engine := NewEngine()
+
+	if err := engine.CreateTopic(NewTopic("foreign")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	mod := &paneModule{}
+	engine.UseModule(mod, []*TopicCfg{})
+	pane := mod.pane
+
+	if err := pane.CreateTopic(NewTopic("owned")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if !pane.ContainsTopic("owned") || pane.ContainsTopic("missing") {
+		t.Fatal("pane reported unexpected topics")
+	}
+
+	if err := pane.DeleteTopic("foreign"); err != ErrEngineModuleNotPermitted {
+		t.Fatalf("expected deleting a foreign topic to be refused, got %v", err)
+	}
+
+	var recvd atomic.Int32
+	if err := pane.SubscribeTo("owned", []Consumer{
+		{
+			Id: "pane.consumer",
+			Fn: func(event *Event) {
+				recvd.Add(1)
+			},
+		},
+	}, true); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	engine.Register(Consumer{Id: "other.consumer", Fn: func(event *Event) {}})
+	if err := engine.SubscribeTo("owned", "other.consumer"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := pane.Unsubscribe("owned", "other.consumer"); err != ErrEngineModuleNotPermitted {
+		t.Fatalf("expected unsubscribing a foreign consumer to be refused, got %v", err)
+	}
+
+	if err := pane.SetModuleMeta(42); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if meta := engine.GetModuleMeta(mod.GetName()); meta != 42 {
+		t.Fatalf("expected meta to be set through the pane, got %v", meta)
+	}
+
+	if pane.IsRunning() {
+		t.Fatal("engine not yet started")
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	pane.SubmitAfter(20*time.Millisecond, "owned", "scheduled")
+	cancel := pane.SubmitAfter(20*time.Millisecond, "owned", "cancelled")
+	cancel()
+
+	time.Sleep(100 * time.Millisecond)
+
+	if recvd.Load() != 1 {
+		t.Fatalf("expected only the scheduled event, got %d", recvd.Load())
+	}
+
+	if err := pane.Unsubscribe("owned", "pane.consumer"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	pane.SubmitTo("owned", "unheard")
+	time.Sleep(50 * time.Millisecond)
+
+	if recvd.Load() != 1 {
+		t.Fatal("unsubscribed consumer received an event")
+	}
+
+	if err := pane.DeleteTopic("owned"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if pane.ContainsTopic("owned") {
+		t.Fatal("expected owned topic to be deleted")
+	}
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
+
+// Sets itself up through the pane as soon as it is handed one
+type eagerModule struct {
+	paneModule
+	recvd atomic.Int32
+}
+
+func (m *eagerModule) RecvModulePane(pane *ModulePane) {
+	m.pane = pane
+	if err := pane.CreateTopic(NewTopic("eager")); err != nil {
+		return
+	}
+	pane.SubscribeTo("eager", []Consumer{
+		{
+			Id: "eager.consumer",
+			Fn: func(event *Event) {
+				m.recvd.Add(1)
+			},
+		},
+	}, true)
+}
+
+func TestModulePaneFromRecv(t *testing.T) {
+
+	engine := NewEngine()
+
+	mod := &eagerModule{}
+
+	used := make(chan error)
+	go func() {
+		used <- engine.UseModule(mod, []*TopicCfg{})
+	}()
+
+	select {
+	case err := <-used:
+		if err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("module could not use its pane while being received")
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	mod.pane.SubmitTo("eager", "hello")
+	time.Sleep(20 * time.Millisecond)
+
+	if mod.recvd.Load() != 1 {
+		t.Fatalf("expected subscription made on receipt of the pane, got %d", mod.recvd.Load())
+	}
+
+	// Scheduled submissions don't outlive the module
+	mod.pane.SubmitAfter(20*time.Millisecond, "eager", "pending")
+
+	if err := engine.RemoveModule(mod.GetName(), false); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	mod.pane.SubmitAfter(time.Millisecond, "eager", "removed")
+
+	engine.Register(Consumer{
+		Id: "observer",
+		Fn: func(event *Event) {
+			mod.recvd.Add(1)
+		},
+	})
+	if err := engine.SubscribeTo("eager", "observer"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	if mod.recvd.Load() != 1 {
+		t.Fatalf("expected nothing to be submitted for a removed module, got %d", mod.recvd.Load())
+	}
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package nerv
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// What happens to an event submitted over its rate limit
+type RateLimitPolicy int
+
+const (
+	RateLimitDelay RateLimitPolicy = iota
+	RateLimitDrop
+	RateLimitReject
+)
+
+var ErrEngineRateLimited = errors.New("rate limited")
+
+// Token bucket configuration. Rate is the number of events per second
+// that are permitted on average, Burst the most that may be submitted
+// at once
+type RateLimit struct {
+	Rate   float64
+	Burst  int
+	Policy RateLimitPolicy
+}
+
+// Token bucket that can be shared by anything that
+// needs to limit the rate of event submission
+type RateLimiter struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	return &RateLimiter{
+		limit:  limit,
+		tokens: float64(limit.Burst),
+		last:   time.Now(),
+	}
+}
+
+func (r *RateLimiter) Policy() RateLimitPolicy {
+	return r.limit.Policy
+}
+
+// Expects r.mu to be held
+func (r *RateLimiter) refill() {
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.limit.Rate
+	r.last = now
+
+	if r.tokens > float64(r.limit.Burst) {
+		r.tokens = float64(r.limit.Burst)
+	}
+}
+
+// Take a token from the bucket. Under the delay policy a token is always
+// taken and the caller is handed how long it must wait before it may use it
+func (r *RateLimiter) reserve() (time.Duration, bool) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.refill()
+
+	if r.tokens >= 1 {
+		r.tokens -= 1
+		return 0, true
+	}
+
+	if r.limit.Policy != RateLimitDelay || r.limit.Rate <= 0 {
+		return 0, false
+	}
+
+	wait := time.Duration((1 - r.tokens) / r.limit.Rate * float64(time.Second))
+	r.tokens -= 1
+	return wait, true
+}
+
+// Put back a token taken for an event that was refused elsewhere
+func (r *RateLimiter) refund() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.tokens = min(r.tokens+1, float64(r.limit.Burst))
+}
+
+// Indicate if an event may proceed. Under the delay policy this blocks
+// until the event is within the limit and always returns true
+func (r *RateLimiter) Admit() bool {
+	wait, ok := r.reserve()
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+	return ok
+}
+
+// Indicate if the bucket has refilled completely, at which point
+// the limiter behaves just as a newly created one would
+func (r *RateLimiter) Idle() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.refill()
+	return r.tokens >= float64(r.limit.Burst)
+}
+
+type heldEvent struct {
+	due  time.Time
+	item queuedEvent
+}
+
+// Events held back by the delay policy of a rate limit. They are
+// released onto the queue in the order that they become due, so
+// that neither their submitter nor the dispatcher waits on them
+type delayLine struct {
+	held    []heldEvent
+	timer   *time.Timer
+	release func(item queuedEvent)
+	mu      sync.Mutex
+}
+
+func newDelayLine(release func(item queuedEvent)) *delayLine {
+	return &delayLine{
+		held:    make([]heldEvent, 0),
+		release: release,
+	}
+}
+
+func (d *delayLine) hold(item queuedEvent, wait time.Duration) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	due := time.Now().Add(wait)
+
+	// Events due at the same time keep the order they were held in
+	i := len(d.held)
+	for i > 0 && d.held[i-1].due.After(due) {
+		i--
+	}
+	d.held = append(d.held, heldEvent{})
+	copy(d.held[i+1:], d.held[i:])
+	d.held[i] = heldEvent{due: due, item: item}
+
+	if i == 0 {
+		d.schedule()
+	}
+}
+
+// Arm the timer for the first held event. Expects d.mu to be held.
+// A timer that fires early finds nothing due and arms itself again
+func (d *delayLine) schedule() {
+	if len(d.held) == 0 {
+		return
+	}
+	wait := time.Until(d.held[0].due)
+	if d.timer == nil {
+		d.timer = time.AfterFunc(wait, d.releaseDue)
+		return
+	}
+	d.timer.Reset(wait)
+}
+
+func (d *delayLine) releaseDue() {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+
+	released := 0
+	for released < len(d.held) && !d.held[released].due.After(now) {
+		d.release(d.held[released].item)
+		released += 1
+	}
+
+	clear(d.held[:released])
+	d.held = d.held[released:]
+	d.schedule()
+}
+
+// Release every held event at once
+func (d *delayLine) flush() {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+
+	for _, held := range d.held {
+		d.release(held.item)
+	}
+	d.held = make([]heldEvent, 0)
+}
+
+func (d *delayLine) len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return len(d.held)
+}
@@ -0,0 +1,54 @@
+package nerv
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var factoryMu sync.Mutex
+var factories = make(map[string]ModuleFactory)
+
+// Make a kind of module available to configurations and NewModule. Meant
+// to be called from the init of the package providing the module, and
+// panics if the kind is already registered
+func RegisterModuleFactory(kind string, factory ModuleFactory) {
+	factoryMu.Lock()
+	defer factoryMu.Unlock()
+
+	if factory == nil {
+		panic("nerv: nil factory registered for module kind " + kind)
+	}
+
+	if _, ok := factories[kind]; ok {
+		panic("nerv: module kind registered twice " + kind)
+	}
+
+	factories[kind] = factory
+}
+
+// Create a module of a registered kind from its settings
+func NewModule(kind string, settings json.RawMessage) (Module, error) {
+	factoryMu.Lock()
+	factory, ok := factories[kind]
+	factoryMu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrConfigUnknownModuleKind, kind)
+	}
+	return factory(settings)
+}
+
+// Kinds of module that are registered, sorted by name
+func ModuleKinds() []string {
+	factoryMu.Lock()
+	defer factoryMu.Unlock()
+
+	kinds := make([]string, 0, len(factories))
+	for kind := range factories {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
@@ -0,0 +1,265 @@
+package nerv
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrEngineInvalidRule = errors.New("forwarding rule requires a source and a destination")
+var ErrEngineDuplicateRule = errors.New("duplicate forwarding rule")
+var ErrEngineUnknownRule = errors.New("unknown forwarding rule")
+var ErrEngineRoutingLoop = errors.New("routing would create a loop")
+var ErrEngineUnknownAlias = errors.New("unknown topic alias")
+
+// Every event submitted to a topic matching one of the From patterns is
+// submitted to To as well, keeping its producer and id. Patterns may
+// contain the same wildcards as producer permissions. Listing several
+// patterns fans the topics in to one
+type ForwardRule struct {
+	From []string
+	To   string
+
+	// Pattern that the producer of an event must match
+	// for it to be forwarded. Empty matches every producer
+	Producer string
+
+	// Optional condition an event must meet to be forwarded
+	When func(event *Event) bool
+}
+
+type forwardRule struct {
+	id   string
+	rule ForwardRule
+}
+
+func Forward(to string, from ...string) *ForwardRule {
+	return &ForwardRule{
+		From: from,
+		To:   to,
+	}
+}
+
+// Only forward events of producers matching the pattern
+func (r *ForwardRule) FromProducer(pattern string) *ForwardRule {
+	r.Producer = pattern
+	return r
+}
+
+// Only forward events meeting the condition
+func (r *ForwardRule) Where(fn func(event *Event) bool) *ForwardRule {
+	r.When = fn
+	return r
+}
+
+func (r *ForwardRule) matches(event *Event) bool {
+
+	if len(r.Producer) > 0 && !MatchTopic(r.Producer, event.Producer) {
+		return false
+	}
+
+	for _, pattern := range r.From {
+		if MatchTopic(pattern, event.Topic) {
+			return r.When == nil || r.When(event)
+		}
+	}
+	return false
+}
+
+// Add a forwarding rule under an id that it can later be removed by.
+// Rules that could forward an event back onto a topic it was already
+// submitted to, directly or through other rules and aliases, are refused
+func (eng *Engine) AddForwardRule(id string, rule *ForwardRule) error {
+
+	eng.log().Debug("AddForwardRule", "id", id, "from", rule.From, "to", rule.To)
+
+	if len(rule.From) == 0 || len(rule.To) == 0 {
+		return ErrEngineInvalidRule
+	}
+
+	eng.routeMu.Lock()
+	defer eng.routeMu.Unlock()
+
+	for _, existing := range eng.rules {
+		if existing.id == id {
+			return ErrEngineDuplicateRule
+		}
+	}
+
+	rules := append(append([]*forwardRule{}, eng.rules...), &forwardRule{
+		id:   id,
+		rule: *rule,
+	})
+
+	if looping := eng.routingLoop(rules); len(looping) > 0 {
+		return fmt.Errorf("%w through %s", ErrEngineRoutingLoop, looping)
+	}
+
+	eng.rules = rules
+	return nil
+}
+
+func (eng *Engine) RemoveForwardRule(id string) error {
+
+	eng.routeMu.Lock()
+	defer eng.routeMu.Unlock()
+
+	// Rules are replaced rather than modified so that
+	// forwarding can make use of them without the lock
+	for i, existing := range eng.rules {
+		if existing.id == id {
+			rules := append([]*forwardRule{}, eng.rules[:i]...)
+			eng.rules = append(rules, eng.rules[i+1:]...)
+			return nil
+		}
+	}
+	return ErrEngineUnknownRule
+}
+
+// Retrieve the ids of the forwarding rules in the order they were added
+func (eng *Engine) ForwardRules() []string {
+
+	eng.routeMu.RLock()
+	defer eng.routeMu.RUnlock()
+
+	ids := make([]string, 0, len(eng.rules))
+	for _, existing := range eng.rules {
+		ids = append(ids, existing.id)
+	}
+	return ids
+}
+
+// Have events submitted to the alias be submitted to the topic instead
+func (eng *Engine) AddTopicAlias(alias string, topic string) error {
+
+	eng.log().Debug("AddTopicAlias", "alias", alias, "topic", topic)
+
+	eng.topicMu.Lock()
+	_, exists := eng.topics[alias]
+	eng.topicMu.Unlock()
+
+	if exists {
+		return ErrEngineDuplicateTopic
+	}
+
+	eng.routeMu.Lock()
+	defer eng.routeMu.Unlock()
+
+	if _, ok := eng.aliases[alias]; ok {
+		return ErrEngineDuplicateTopic
+	}
+
+	if eng.resolveAlias(topic) == alias {
+		return fmt.Errorf("%w: %s aliases itself", ErrEngineRoutingLoop, alias)
+	}
+
+	eng.aliases[alias] = topic
+
+	if looping := eng.routingLoop(eng.rules); len(looping) > 0 {
+		delete(eng.aliases, alias)
+		return fmt.Errorf("%w through %s", ErrEngineRoutingLoop, looping)
+	}
+	return nil
+}
+
+func (eng *Engine) RemoveTopicAlias(alias string) error {
+
+	eng.routeMu.Lock()
+	defer eng.routeMu.Unlock()
+
+	if _, ok := eng.aliases[alias]; !ok {
+		return ErrEngineUnknownAlias
+	}
+
+	delete(eng.aliases, alias)
+	return nil
+}
+
+// Retrieve the topic that events submitted to a topic are delivered on
+func (eng *Engine) ResolveTopic(topic string) string {
+
+	eng.routeMu.RLock()
+	defer eng.routeMu.RUnlock()
+
+	return eng.resolveAlias(topic)
+}
+
+// Expects eng.routeMu to be held. Aliases never form a cycle
+func (eng *Engine) resolveAlias(topic string) string {
+	for {
+		target, ok := eng.aliases[topic]
+		if !ok {
+			return topic
+		}
+		topic = target
+	}
+}
+
+// Retrieve the id of a rule whose events could be forwarded back onto
+// one of its sources, if any. Expects eng.routeMu to be held
+func (eng *Engine) routingLoop(rules []*forwardRule) string {
+	for _, existing := range rules {
+		if eng.forwardsInto(rules, existing.rule.To, existing.rule.From) {
+			return existing.id
+		}
+	}
+	return ""
+}
+
+// Determine whether an event arriving on a topic could be forwarded
+// by the rules onto a topic matching any of the patterns. Expects
+// eng.routeMu to be held
+func (eng *Engine) forwardsInto(rules []*forwardRule, topic string, patterns []string) bool {
+
+	visited := make(map[string]bool)
+	pending := []string{eng.resolveAlias(topic)}
+
+	for len(pending) > 0 {
+		current := pending[0]
+		pending = pending[1:]
+
+		if visited[current] {
+			continue
+		}
+		visited[current] = true
+
+		for _, pattern := range patterns {
+			if MatchTopic(pattern, current) {
+				return true
+			}
+		}
+
+		for _, existing := range rules {
+			for _, from := range existing.rule.From {
+				if MatchTopic(from, current) {
+					pending = append(pending, eng.resolveAlias(existing.rule.To))
+					break
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Submit copies of an event to the destinations of the rules it matches.
+// Copies keep the producer and id of the event, and as the event was
+// already permitted, are not held to the producer's permissions again.
+// Each copy counts as a hop
+func (eng *Engine) applyForwarding(event *Event) {
+
+	eng.routeMu.RLock()
+	rules := eng.rules
+	eng.routeMu.RUnlock()
+
+	for _, existing := range rules {
+		if !existing.rule.matches(event) {
+			continue
+		}
+		to := existing.rule.To
+		forwarded := resubmission(event, *event)
+		forwarded.Topic = to
+		forwarded.Offset = 0
+		if err := eng.submitEvent(forwarded, false); err != nil {
+			eng.log().Debug("failed to forward event", "from", event.Topic, "to", to, "err", err.Error())
+		}
+	}
+}
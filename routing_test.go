@@ -0,0 +1,147 @@
+package nerv
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestForwardRules(t *testing.T) {
+
+	engine := NewEngine()
+
+	for _, topic := range []string{"topic.http", "orders.in", "alerts.disk", "alerts.cpu", "alarms", "all.alerts"} {
+		if err := engine.CreateTopic(NewTopic(topic)); err != nil {
+			t.Fatalf("err: %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	recvd := make(map[string][]*Event)
+
+	engine.Register(Consumer{
+		Id: "recorder",
+		Fn: func(event *Event) {
+			mu.Lock()
+			recvd[event.Topic] = append(recvd[event.Topic], event)
+			mu.Unlock()
+		},
+	})
+	engine.SubscribeTo("orders.in", "recorder")
+	engine.SubscribeTo("all.alerts", "recorder")
+
+	if err := engine.AddForwardRule("partners", Forward("orders.in", "topic.http").FromProducer("partner.*")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.AddForwardRule("alerts", Forward("all.alerts", "alerts.*", "alarms")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.AddForwardRule("partners", Forward("alarms", "orders.in")); err != ErrEngineDuplicateRule {
+		t.Fatalf("expected duplicate rule to be refused, got %v", err)
+	}
+
+	if err := engine.AddTopicAlias("legacy.orders", "orders.in"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	engine.SubmitEvent(Event{Spawned: time.Now(), Topic: "topic.http", Producer: "partner.acme", Id: "order-1"})
+	engine.SubmitEvent(Event{Spawned: time.Now(), Topic: "topic.http", Producer: "stranger", Id: "order-2"})
+	engine.SubmitEvent(Event{Spawned: time.Now(), Topic: "legacy.orders", Producer: "old.shop", Id: "order-3"})
+
+	for _, topic := range []string{"alerts.disk", "alerts.cpu", "alarms", "topic.http"} {
+		engine.Submit("monitor", topic, nil)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	// Removed rules no longer forward
+	if err := engine.RemoveForwardRule("alerts"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	engine.Submit("monitor", "alarms", nil)
+
+	time.Sleep(50 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// Forwarded copies are submitted once the original is dispatched,
+	// so they may arrive after events submitted to the alias directly
+	orders := make(map[string]*Event)
+	for _, event := range recvd["orders.in"] {
+		orders[event.Id] = event
+	}
+
+	if len(orders) != 2 || orders["order-1"] == nil || orders["order-3"] == nil {
+		t.Fatalf("expected forwarded and aliased orders, got %v", orders)
+	}
+
+	if orders["order-1"].Producer != "partner.acme" {
+		t.Fatalf("expected forwarded event to keep its producer, got %s", orders["order-1"].Producer)
+	}
+
+	if orders["order-3"].Producer != "old.shop" {
+		t.Fatalf("expected event submitted to alias, got %s", orders["order-3"].Producer)
+	}
+
+	if len(recvd["all.alerts"]) != 3 {
+		t.Fatalf("expected alerts to fan in, got %d", len(recvd["all.alerts"]))
+	}
+}
+
+func TestForwardRuleLoops(t *testing.T) {
+
+	engine := NewEngine()
+
+	if err := engine.AddForwardRule("self", Forward("a", "a")); !errors.Is(err, ErrEngineRoutingLoop) {
+		t.Fatalf("expected self forwarding to be refused, got %v", err)
+	}
+
+	if err := engine.AddForwardRule("a-b", Forward("b", "a")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.AddForwardRule("b-c", Forward("c", "b")); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.AddForwardRule("c-a", Forward("a", "c.*", "c")); !errors.Is(err, ErrEngineRoutingLoop) {
+		t.Fatalf("expected indirect loop to be refused, got %v", err)
+	}
+
+	if err := engine.AddForwardRule("wild", Forward("x.y", "x.*")); !errors.Is(err, ErrEngineRoutingLoop) {
+		t.Fatalf("expected destination matching source pattern to be refused, got %v", err)
+	}
+
+	// Forwarding onto an alias of a source is forwarding onto the source
+	if err := engine.AddTopicAlias("c", "a"); !errors.Is(err, ErrEngineRoutingLoop) {
+		t.Fatalf("expected alias closing a loop to be refused, got %v", err)
+	}
+
+	if err := engine.AddTopicAlias("d", "e"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.AddTopicAlias("e", "d"); !errors.Is(err, ErrEngineRoutingLoop) {
+		t.Fatalf("expected alias cycle to be refused, got %v", err)
+	}
+
+	if err := engine.RemoveForwardRule("b-c"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.AddForwardRule("c-a", Forward("a", "c")); err != nil {
+		t.Fatalf("expected rule to be permitted once the loop is broken, got %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package nerv
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// Operators that thin out the events delivered to the consumers of
+// a topic. Operators are applied in the order sampling, throttling
+// and then debouncing. Zero values disable an operator
+type ShapingCfg struct {
+
+	// Only deliver an event once no other event has been
+	// submitted to the topic for this long
+	Debounce time.Duration
+
+	// Deliver at most one event per interval for each key
+	Throttle    time.Duration
+	ThrottleKey func(event *Event) string
+
+	// Probability in (0, 1) that any given event is delivered
+	SampleRate float64
+
+	// Only deliver every nth event
+	EveryNth int
+}
+
+type topicShaper struct {
+	cfg       ShapingCfg
+	seen      uint64
+	throttled map[string]time.Time
+	pending   *Event
+	timer     *time.Timer
+	mu        sync.Mutex
+
+	// Incremented with every debounced event, so that a timer which
+	// fired for an event that was since replaced releases nothing
+	generation uint64
+}
+
+func newTopicShaper(cfg ShapingCfg) *topicShaper {
+	return &topicShaper{
+		cfg:       cfg,
+		throttled: make(map[string]time.Time),
+	}
+}
+
+// Indicate if the event should be delivered now. Debounced events are
+// held back and handed to release once the topic has gone quiet
+func (s *topicShaper) admit(event *Event, release func(event *Event)) bool {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seen += 1
+
+	if s.cfg.EveryNth > 1 && s.seen%uint64(s.cfg.EveryNth) != 0 {
+		return false
+	}
+
+	if s.cfg.SampleRate > 0 && s.cfg.SampleRate < 1 && rand.Float64() >= s.cfg.SampleRate {
+		return false
+	}
+
+	if s.cfg.Throttle > 0 {
+		key := ""
+		if s.cfg.ThrottleKey != nil {
+			key = s.cfg.ThrottleKey(event)
+		}
+		now := time.Now()
+		if last, ok := s.throttled[key]; ok && now.Sub(last) < s.cfg.Throttle {
+			return false
+		}
+		s.throttled[key] = now
+		s.expireThrottled(now)
+	}
+
+	if s.cfg.Debounce > 0 {
+		s.generation += 1
+		generation := s.generation
+		// Held as a copy, as the event the engine has
+		// carries on being used once it is dispatched
+		s.pending = event.clone()
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+		s.timer = time.AfterFunc(s.cfg.Debounce, func() {
+			s.settle(generation, release)
+		})
+		return false
+	}
+
+	return true
+}
+
+// Release the debounced event, provided no other has
+// replaced it since the timer was started for it
+func (s *topicShaper) settle(generation uint64, release func(event *Event)) {
+
+	s.mu.Lock()
+	if generation != s.generation || s.pending == nil {
+		s.mu.Unlock()
+		return
+	}
+	pending := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+
+	release(pending)
+}
+
+// Forget keys whose interval has long passed so the set of
+// throttled keys doesn't grow without bound
+func (s *topicShaper) expireThrottled(now time.Time) {
+	if len(s.throttled) < 1024 {
+		return
+	}
+	for key, last := range s.throttled {
+		if now.Sub(last) >= s.cfg.Throttle {
+			delete(s.throttled, key)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package nerv
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func runShapedTopic(t *testing.T, cfg *TopicCfg, submit func(engine *Engine)) *replayRecvr {
+
+	engine := NewEngine()
+
+	if err := engine.CreateTopic(cfg); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	recvr := &replayRecvr{}
+	engine.Register(Consumer{"sensor.reader", recvr.Accept})
+
+	if err := engine.SubscribeTo(cfg.Name, "sensor.reader"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	submit(engine)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return recvr
+}
+
+func TestDebounce(t *testing.T) {
+
+	recvr := runShapedTopic(t,
+		NewTopic("sensor").UsingDebounce(50*time.Millisecond),
+		func(engine *Engine) {
+			for i := 0; i < 5; i++ {
+				engine.Submit("sensor", "sensor", i)
+				time.Sleep(5 * time.Millisecond)
+			}
+			time.Sleep(150 * time.Millisecond)
+			engine.Submit("sensor", "sensor", 5)
+			time.Sleep(150 * time.Millisecond)
+		})
+
+	expectSequence(t, "debounce", recvr.get(), []int{4, 5})
+}
+
+func TestThrottle(t *testing.T) {
+
+	recvr := runShapedTopic(t,
+		NewTopic("sensor").UsingThrottle(100*time.Millisecond, func(event *Event) string {
+			return fmt.Sprintf("%d", event.Data.(int)%2)
+		}),
+		func(engine *Engine) {
+			for i := 0; i < 6; i++ {
+				engine.Submit("sensor", "sensor", i)
+			}
+			time.Sleep(150 * time.Millisecond)
+			engine.Submit("sensor", "sensor", 6)
+			time.Sleep(50 * time.Millisecond)
+		})
+
+	expectSequence(t, "throttle", recvr.get(), []int{0, 1, 6})
+}
+
+func TestSampling(t *testing.T) {
+
+	recvr := runShapedTopic(t,
+		NewTopic("sensor").UsingEveryNth(3),
+		func(engine *Engine) {
+			for i := 1; i <= 9; i++ {
+				engine.Submit("sensor", "sensor", i)
+			}
+			time.Sleep(50 * time.Millisecond)
+		})
+
+	expectSequence(t, "every nth", recvr.get(), []int{3, 6, 9})
+
+	var admitted atomic.Int32
+	shaper := newTopicShaper(ShapingCfg{SampleRate: 0.5})
+	for i := 0; i < 1000; i++ {
+		if shaper.admit(&Event{}, nil) {
+			admitted.Add(1)
+		}
+	}
+
+	if admitted.Load() < 350 || admitted.Load() > 650 {
+		t.Fatalf("improbability: %d of 1000 events sampled at rate 0.5", admitted.Load())
+	}
+}
+
+func TestDebounceStaleTimer(t *testing.T) {
+
+	shaper := newTopicShaper(ShapingCfg{Debounce: time.Minute})
+
+	released := make([]*Event, 0)
+	release := func(event *Event) {
+		released = append(released, event)
+	}
+
+	shaper.admit(&Event{Data: 0}, release)
+	stale := shaper.generation
+	shaper.admit(&Event{Data: 1}, release)
+
+	// The timer of the replaced event fired before it could be stopped
+	shaper.settle(stale, release)
+
+	if len(released) != 0 {
+		t.Fatalf("expected stale timer to release nothing, got %d events", len(released))
+	}
+
+	shaper.settle(shaper.generation, release)
+
+	if len(released) != 1 || released[0].Data.(int) != 1 {
+		t.Fatalf("expected only the latest event to be released, got %v", released)
+	}
+}
@@ -0,0 +1,292 @@
+package nerv
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	windowTumbling = iota
+	windowSliding
+	windowSession
+)
+
+var ErrStreamInvalidWindow = errors.New("window sizes, slides and gaps must be positive")
+
+var streamCount atomic.Uint64
+
+// How events of a stream are grouped together for aggregation
+type WindowCfg struct {
+	kind  int
+	size  time.Duration
+	slide time.Duration
+	gap   time.Duration
+}
+
+// Fixed size, non-overlapping windows aligned to multiples of size
+func Tumbling(size time.Duration) WindowCfg {
+	return WindowCfg{kind: windowTumbling, size: size}
+}
+
+// Windows of the given size that are evaluated every slide
+func Sliding(size time.Duration, slide time.Duration) WindowCfg {
+	return WindowCfg{kind: windowSliding, size: size, slide: slide}
+}
+
+// Windows that close once no event has arrived for gap
+func Session(gap time.Duration) WindowCfg {
+	return WindowCfg{kind: windowSession, gap: gap}
+}
+
+func (w WindowCfg) valid() bool {
+	switch w.kind {
+	case windowTumbling:
+		return w.size > 0
+	case windowSliding:
+		return w.size > 0 && w.slide > 0
+	case windowSession:
+		return w.gap > 0
+	}
+	return false
+}
+
+// Combine the events of a window into the data of a single event
+type Aggregator func(events []*Event) interface{}
+
+// Given what to do with an event, produce the function
+// that the previous stage hands its events to
+type streamStage func(emit EventRecvr) EventRecvr
+
+// A stream is a declared pipeline of operators that is fed by a topic
+// and published to another. Nothing is registered with the engine until
+// To is called, at which point the stream becomes an internal consumer of
+// its source and producer of its destination
+type Stream struct {
+	eng    *Engine
+	source string
+	stages []streamStage
+
+	// First problem with the declaration, reported by To
+	err error
+}
+
+// A stream whose events are grouped into windows, awaiting aggregation
+type WindowedStream struct {
+	stream *Stream
+	window WindowCfg
+}
+
+// Start declaring a stream fed by the given topic
+func From(eng *Engine, topic string) *Stream {
+	return &Stream{
+		eng:    eng,
+		source: topic,
+		stages: make([]streamStage, 0),
+	}
+}
+
+// Only pass on events that satisfy the predicate
+func (s *Stream) Filter(fn func(event *Event) bool) *Stream {
+	s.stages = append(s.stages, func(emit EventRecvr) EventRecvr {
+		return func(event *Event) {
+			if fn(event) {
+				emit(event)
+			}
+		}
+	})
+	return s
+}
+
+// Replace the data of each event with the result of fn
+func (s *Stream) Map(fn func(event *Event) interface{}) *Stream {
+	s.stages = append(s.stages, func(emit EventRecvr) EventRecvr {
+		return func(event *Event) {
+			mapped := *event
+			mapped.Data = fn(event)
+			emit(&mapped)
+		}
+	})
+	return s
+}
+
+// Group the events of the stream into windows
+func (s *Stream) Window(window WindowCfg) *WindowedStream {
+	return &WindowedStream{
+		stream: s,
+		window: window,
+	}
+}
+
+// Reduce each window to a single event whose data is the result of fn
+func (w *WindowedStream) Aggregate(fn Aggregator) *Stream {
+	if !w.window.valid() {
+		w.stream.err = ErrStreamInvalidWindow
+		return w.stream
+	}
+	eng := w.stream.eng
+	window := w.window
+	w.stream.stages = append(w.stream.stages, func(emit EventRecvr) EventRecvr {
+		return newWindower(eng, window, fn, emit).add
+	})
+	return w.stream
+}
+
+// Publish the result of the stream onto the given topic, creating it as
+// a broadcast topic if it doesn't exist, and start consuming the source.
+// Streams declared with a window that isn't positive are refused
+func (s *Stream) To(topic string) error {
+
+	if s.err != nil {
+		return s.err
+	}
+
+	id := fmt.Sprintf("stream:%d:%s", streamCount.Add(1), s.source)
+
+	if err := s.eng.CreateTopic(NewTopic(topic)); err != nil && !errors.Is(err, ErrEngineDuplicateTopic) {
+		return err
+	}
+
+	// Streams are declared on the engine, so their
+	// output isn't held to producer permissions
+	pipeline := EventRecvr(func(event *Event) {
+		if err := s.eng.submitEvent(resubmission(event, Event{
+			Spawned:  event.Spawned,
+			Topic:    topic,
+			Producer: id,
+			Data:     event.Data,
+		}), false); err != nil {
+			s.eng.log().Debug("stream failed to submit", "stream", id, "topic", topic, "err", err.Error())
+		}
+	})
+
+	for i := len(s.stages) - 1; i >= 0; i-- {
+		pipeline = s.stages[i](pipeline)
+	}
+
+	s.eng.log().Debug("stream", "id", id, "from", s.source, "to", topic)
+
+	s.eng.declareRoute(id, []string{s.source}, topic)
+
+	s.eng.Register(Consumer{
+		Id: id,
+		Fn: pipeline,
+	})
+
+	return s.eng.SubscribeTo(s.source, id)
+}
+
+type windowedEvent struct {
+	arrived time.Time
+	event   *Event
+}
+
+type windower struct {
+	eng       *Engine
+	cfg       WindowCfg
+	aggregate Aggregator
+	emit      EventRecvr
+	buffered  []windowedEvent
+	timer     *time.Timer
+	mu        sync.Mutex
+}
+
+func newWindower(eng *Engine, cfg WindowCfg, aggregate Aggregator, emit EventRecvr) *windower {
+	w := &windower{
+		eng:       eng,
+		cfg:       cfg,
+		aggregate: aggregate,
+		emit:      emit,
+		buffered:  make([]windowedEvent, 0),
+	}
+
+	if cfg.kind == windowSliding {
+		go w.slide()
+	}
+	return w
+}
+
+func (w *windower) add(event *Event) {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now()
+	w.buffered = append(w.buffered, windowedEvent{now, event})
+
+	switch w.cfg.kind {
+	case windowTumbling:
+		if w.timer == nil {
+			closes := now.Truncate(w.cfg.size).Add(w.cfg.size)
+			w.timer = time.AfterFunc(closes.Sub(now), w.close)
+		}
+	case windowSession:
+		if w.timer != nil {
+			w.timer.Stop()
+		}
+		w.timer = time.AfterFunc(w.cfg.gap, w.close)
+	}
+}
+
+// Aggregate and discard everything buffered
+func (w *windower) close() {
+	w.mu.Lock()
+	events := w.take(time.Time{})
+	w.buffered = w.buffered[:0]
+	w.timer = nil
+	w.mu.Unlock()
+
+	w.publish(events)
+}
+
+// Every slide, aggregate what arrived within the last window size
+func (w *windower) slide() {
+	ticker := time.NewTicker(w.cfg.slide)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.eng.ctx.Done():
+			return
+		case now := <-ticker.C:
+			w.mu.Lock()
+			events := w.take(now.Add(-w.cfg.size))
+			w.mu.Unlock()
+
+			w.publish(events)
+		}
+	}
+}
+
+// Retrieve the buffered events that arrived after the given time,
+// forgetting any older ones. Expects w.mu to be held
+func (w *windower) take(after time.Time) []*Event {
+	retained := w.buffered[:0]
+	events := make([]*Event, 0, len(w.buffered))
+	for _, buffered := range w.buffered {
+		if buffered.arrived.Before(after) {
+			continue
+		}
+		retained = append(retained, buffered)
+		events = append(events, buffered.event)
+	}
+	w.buffered = retained
+	return events
+}
+
+func (w *windower) publish(events []*Event) {
+	if len(events) == 0 || w.eng.ctx.Err() != nil {
+		return
+	}
+	// Aggregates carry on the path of the furthest travelled event
+	last := furthest(events)
+	w.emit(&Event{
+		Spawned: time.Now(),
+		Topic:   events[0].Topic,
+		Data:    w.aggregate(events),
+		Hops:    last.Hops,
+		Trail:   last.Trail,
+	})
+}
@@ -0,0 +1,192 @@
+package nerv
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type aggregateRecvr struct {
+	mu    sync.Mutex
+	recvd []interface{}
+}
+
+func (r *aggregateRecvr) Accept(event *Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.recvd = append(r.recvd, event.Data)
+}
+
+func (r *aggregateRecvr) get() []interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]interface{}{}, r.recvd...)
+}
+
+func sumInts(events []*Event) interface{} {
+	sum := 0
+	for _, event := range events {
+		sum += event.Data.(int)
+	}
+	return sum
+}
+
+func TestStreamWindows(t *testing.T) {
+
+	engine := NewEngine()
+
+	if err := engine.CreateTopic(NewTopic("sensor.temp")); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	if err := From(engine, "sensor.temp").
+		Filter(func(event *Event) bool {
+			return event.Data.(int) >= 0
+		}).
+		Map(func(event *Event) interface{} {
+			return event.Data.(int) * 10
+		}).
+		To("sensor.temp.scaled"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := From(engine, "sensor.temp.scaled").
+		Window(Session(50 * time.Millisecond)).
+		Aggregate(sumInts).
+		To("sensor.temp.session"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := From(engine, "sensor.temp").
+		Window(Tumbling(time.Hour)).
+		Aggregate(sumInts).
+		To("sensor.temp.hourly"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	scaled := &aggregateRecvr{}
+	session := &aggregateRecvr{}
+	hourly := &aggregateRecvr{}
+
+	engine.Register(Consumer{"scaled", scaled.Accept})
+	engine.Register(Consumer{"session", session.Accept})
+	engine.Register(Consumer{"hourly", hourly.Accept})
+
+	if err := engine.SubscribeTo("sensor.temp.scaled", "scaled"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := engine.SubscribeTo("sensor.temp.session", "session"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if err := engine.SubscribeTo("sensor.temp.hourly", "hourly"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for _, value := range []int{1, -5, 2, 3} {
+		engine.Submit("thermometer", "sensor.temp", value)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	engine.Submit("thermometer", "sensor.temp", 4)
+
+	time.Sleep(200 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if recvd := scaled.get(); len(recvd) != 4 || recvd[0].(int) != 10 || recvd[3].(int) != 40 {
+		t.Fatalf("unexpected filtered and mapped stream %v", recvd)
+	}
+
+	if recvd := session.get(); len(recvd) != 2 || recvd[0].(int) != 60 || recvd[1].(int) != 40 {
+		t.Fatalf("unexpected session windows %v", recvd)
+	}
+
+	if recvd := hourly.get(); len(recvd) != 0 {
+		t.Fatalf("tumbling window closed early %v", recvd)
+	}
+}
+
+func TestStreamSlidingWindow(t *testing.T) {
+
+	engine := NewEngine()
+
+	if err := engine.CreateTopic(NewTopic("clicks")); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	if err := From(engine, "clicks").
+		Window(Sliding(150*time.Millisecond, 50*time.Millisecond)).
+		Aggregate(func(events []*Event) interface{} {
+			return len(events)
+		}).
+		To("clicks.recent"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	counts := &aggregateRecvr{}
+	engine.Register(Consumer{"counts", counts.Accept})
+
+	if err := engine.SubscribeTo("clicks.recent", "counts"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		engine.Submit("mouse", "clicks", i)
+	}
+
+	time.Sleep(400 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	recvd := counts.get()
+	if len(recvd) < 2 || len(recvd) > 3 {
+		t.Fatalf("expected the clicks to be seen by 2-3 overlapping windows, got %v", recvd)
+	}
+	for _, count := range recvd {
+		if count.(int) != 3 {
+			t.Fatalf("unexpected window count %v", recvd)
+		}
+	}
+}
+
+func TestStreamInvalidWindows(t *testing.T) {
+
+	engine := NewEngine()
+
+	if err := engine.CreateTopic(NewTopic("sensor.temp")); err != nil {
+		t.Fatalf("err:%v", err)
+	}
+
+	for _, window := range []WindowCfg{
+		Tumbling(0),
+		Tumbling(-time.Second),
+		Sliding(time.Second, 0),
+		Sliding(0, time.Second),
+		Session(0),
+	} {
+		err := From(engine, "sensor.temp").
+			Window(window).
+			Aggregate(sumInts).
+			To("sensor.temp.sum")
+		if err != ErrStreamInvalidWindow {
+			t.Fatalf("expected window %+v to be refused, got %v", window, err)
+		}
+	}
+
+	if engine.ContainsConsumer(new(string)) {
+		t.Fatal("expected no stream to be registered")
+	}
+}
@@ -0,0 +1,211 @@
+package nerv
+
+import (
+	"time"
+)
+
+// Configuration of the supervision of modules implementing HealthChecker.
+// Each module is checked every Interval. An unhealthy module is restarted
+// after Backoff, which doubles with every consecutive restart up to MaxBackoff.
+// Should a module be restarted more than MaxRestarts times within Period the
+// engine gives up on it and leaves it be
+type SupervisorCfg struct {
+	Interval    time.Duration
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	MaxRestarts int
+	Period      time.Duration
+}
+
+// Data of the event published on nerv.internal when the health
+// of a supervised module changes, or the engine gives up on it
+type ModuleHealth struct {
+	Module   string
+	Healthy  bool
+	Error    string
+	Restarts int
+	GaveUp   bool
+}
+
+type supervisedModule struct {
+	healthy     bool
+	backoff     time.Duration
+	restartAt   time.Time
+	restarts    []time.Time
+	total       int
+	gaveUp      bool
+	unsupported bool
+}
+
+type supervisor struct {
+	eng     *Engine
+	cfg     SupervisorCfg
+	modules map[string]*supervisedModule
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+// Supervise the modules implementing HealthChecker once the engine starts
+func (eng *Engine) WithSupervision(cfg SupervisorCfg) *Engine {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Second
+	}
+	if cfg.MaxBackoff < cfg.Backoff {
+		cfg.MaxBackoff = cfg.Backoff
+	}
+	eng.supervisorCfg = &cfg
+	return eng
+}
+
+func newSupervisor(eng *Engine, cfg SupervisorCfg) *supervisor {
+	return &supervisor{
+		eng:     eng,
+		cfg:     cfg,
+		modules: make(map[string]*supervisedModule),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func (s *supervisor) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.quit:
+			return
+		case now := <-ticker.C:
+			s.eng.modMu.Lock()
+			started := append([]Module(nil), s.eng.started...)
+			s.eng.modMu.Unlock()
+
+			current := make(map[string]*supervisedModule)
+			for _, mod := range started {
+				if state, ok := s.modules[mod.GetName()]; ok {
+					current[mod.GetName()] = state
+				}
+			}
+
+			// Forget modules that were removed since
+			s.modules = current
+
+			for _, mod := range started {
+				s.check(mod, now)
+			}
+		}
+	}
+}
+
+// Stop supervising, waiting on any restart underway
+func (s *supervisor) stop() {
+	close(s.quit)
+	<-s.done
+}
+
+func (s *supervisor) check(mod Module, now time.Time) {
+
+	name := mod.GetName()
+
+	state, ok := s.modules[name]
+	if !ok {
+		state = &supervisedModule{
+			healthy: true,
+			backoff: s.cfg.Backoff,
+		}
+		s.modules[name] = state
+	}
+
+	if state.gaveUp || state.unsupported {
+		return
+	}
+
+	checker, ok := mod.(HealthChecker)
+	if !ok {
+		state.unsupported = true
+		return
+	}
+
+	err := checker.Health()
+
+	if err == nil {
+		if !state.healthy {
+			s.eng.log().Info("module recovered", "module", name)
+			state.healthy = true
+			state.backoff = s.cfg.Backoff
+			s.announce(name, state, nil)
+		}
+		return
+	}
+
+	if state.healthy {
+		s.eng.log().Warn("module unhealthy", "module", name, "err", err.Error())
+		state.healthy = false
+		state.restartAt = now.Add(state.backoff)
+		s.announce(name, state, err)
+	}
+
+	if now.Before(state.restartAt) {
+		return
+	}
+
+	recent := state.restarts[:0]
+	for _, at := range state.restarts {
+		if now.Sub(at) < s.cfg.Period {
+			recent = append(recent, at)
+		}
+	}
+	state.restarts = recent
+
+	if len(state.restarts) >= s.cfg.MaxRestarts {
+		s.eng.log().Error("giving up on module", "module", name, "restarts", len(state.restarts), "period", s.cfg.Period)
+		state.gaveUp = true
+		s.announce(name, state, err)
+		return
+	}
+
+	s.eng.lifeMu.Lock()
+	defer s.eng.lifeMu.Unlock()
+
+	// The module may have been removed since it was checked
+	s.eng.modMu.Lock()
+	started := s.eng.isStarted(name)
+	s.eng.modMu.Unlock()
+
+	if !started {
+		return
+	}
+
+	s.eng.log().Info("restarting module", "module", name, "backoff", state.backoff)
+
+	if err := s.eng.shutdownModule(mod); err != nil {
+		s.eng.log().Warn("module did not shut down in time", "module", name, "timeout", s.eng.shutdownTimeoutOf(mod))
+	}
+	if err := s.eng.invokeStart(mod); err != nil {
+		s.eng.log().Error("module failed to restart", "module", name, "err", err.Error())
+	}
+
+	state.restarts = append(state.restarts, now)
+	state.total += 1
+
+	state.backoff *= 2
+	if state.backoff > s.cfg.MaxBackoff {
+		state.backoff = s.cfg.MaxBackoff
+	}
+	state.restartAt = now.Add(state.backoff)
+}
+
+func (s *supervisor) announce(name string, state *supervisedModule, err error) {
+	health := &ModuleHealth{
+		Module:   name,
+		Healthy:  state.healthy,
+		Restarts: state.total,
+		GaveUp:   state.gaveUp,
+	}
+	if err != nil {
+		health.Error = err.Error()
+	}
+	s.eng.publishInternal(TopicInternal, health)
+}
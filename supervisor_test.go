@@ -0,0 +1,191 @@
+package nerv
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errFlakyModule = errors.New("flaky module is unwell")
+
+type flakyModule struct {
+	name     string
+	starts   int
+	recovers bool
+	healthy  bool
+	mu       sync.Mutex
+}
+
+func (m *flakyModule) GetName() string {
+	return m.name
+}
+
+func (m *flakyModule) RecvModulePane(pane *ModulePane) {}
+
+func (m *flakyModule) Start() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.starts += 1
+	m.healthy = m.starts == 1 || m.recovers
+	return nil
+}
+
+func (m *flakyModule) Shutdown() {}
+
+func (m *flakyModule) Health() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.healthy {
+		return nil
+	}
+	return errFlakyModule
+}
+
+func (m *flakyModule) fail() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.healthy = false
+}
+
+func (m *flakyModule) startCount() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.starts
+}
+
+func TestSupervision(t *testing.T) {
+
+	engine := NewEngine().WithSupervision(SupervisorCfg{
+		Interval:    10 * time.Millisecond,
+		Backoff:     20 * time.Millisecond,
+		MaxBackoff:  40 * time.Millisecond,
+		MaxRestarts: 2,
+		Period:      time.Minute,
+	})
+
+	recovering := &flakyModule{name: "recovering", recovers: true}
+	failing := &flakyModule{name: "failing"}
+
+	engine.UseModule(recovering, []*TopicCfg{})
+	engine.UseModule(failing, []*TopicCfg{})
+
+	healthMu := new(sync.Mutex)
+	health := make(map[string][]*ModuleHealth)
+
+	engine.Register(Consumer{
+		Id: "health",
+		Fn: func(event *Event) {
+			if h, ok := event.Data.(*ModuleHealth); ok {
+				healthMu.Lock()
+				defer healthMu.Unlock()
+				health[h.Module] = append(health[h.Module], h)
+			}
+		},
+	})
+
+	if err := engine.SubscribeTo(TopicInternal, "health"); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	recovering.fail()
+	failing.fail()
+
+	time.Sleep(300 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if recovering.startCount() != 2 {
+		t.Fatalf("expected recovering module to be restarted once, got %d starts", recovering.startCount())
+	}
+
+	if failing.startCount() != 3 {
+		t.Fatalf("expected failing module to be restarted twice, got %d starts", failing.startCount())
+	}
+
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	transitions := health["recovering"]
+	if len(transitions) != 2 || transitions[0].Healthy || !transitions[1].Healthy || transitions[1].Restarts != 1 {
+		t.Fatalf("expected unhealthy then healthy transitions, got %+v", transitions)
+	}
+
+	transitions = health["failing"]
+	if len(transitions) != 2 || transitions[0].Error != errFlakyModule.Error() || !transitions[1].GaveUp {
+		t.Fatalf("expected unhealthy transition then giving up, got %+v", transitions)
+	}
+}
+
+// Subscribes through its pane every time it is started
+type listeningModule struct {
+	flakyModule
+	pane      *ModulePane
+	delivered int
+}
+
+func (m *listeningModule) RecvModulePane(pane *ModulePane) {
+	m.pane = pane
+}
+
+func (m *listeningModule) Start() error {
+	m.flakyModule.Start()
+	return m.pane.SubscribeTo("readings", []Consumer{{
+		Id: "listener",
+		Fn: func(event *Event) {
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.delivered += 1
+		},
+	}}, true)
+}
+
+func TestSupervisedRestartKeepsSubscriptions(t *testing.T) {
+
+	engine := NewEngine().WithSupervision(SupervisorCfg{
+		Interval:    10 * time.Millisecond,
+		Backoff:     20 * time.Millisecond,
+		MaxBackoff:  40 * time.Millisecond,
+		MaxRestarts: 2,
+		Period:      time.Minute,
+	})
+
+	listening := &listeningModule{flakyModule: flakyModule{name: "listening", recovers: true}}
+
+	if err := engine.UseModule(listening, []*TopicCfg{NewTopic("readings")}); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if err := engine.Start(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	listening.fail()
+
+	time.Sleep(100 * time.Millisecond)
+
+	if listening.startCount() != 2 {
+		t.Fatalf("expected module to be restarted once, got %d starts", listening.startCount())
+	}
+
+	engine.Submit("sensor", "readings", 1)
+
+	time.Sleep(20 * time.Millisecond)
+
+	if err := engine.Stop(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	listening.mu.Lock()
+	defer listening.mu.Unlock()
+
+	if listening.delivered != 1 {
+		t.Fatalf("expected a single delivery after restart, got %d", listening.delivered)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"math/rand/v2"
 	"sync"
+	"time"
 )
 
 const (
@@ -18,19 +19,54 @@ const (
 )
 
 var ErrTopicNoSubscriberFound = errors.New("no subscriber found")
+var ErrTopicNoLastValue = errors.New("no last value cached for topic")
+
+type subscriber struct {
+	id string
+	fn EventRecvr
+}
 
 type eventTopic struct {
 	distributionType int
 	selectionType    int
-	subscribed       []EventRecvr
+	subscribed       []*subscriber
 	rrIdx            int
 	rrMu             sync.Mutex
+	log              *topicLog
+	lastValues       []*Event
+	lastValueCap     int
+	dedup            *dedupCache
+	limiter          *RateLimiter
+	shaper           *topicShaper
+	deleting         bool
+
+	// Consumer groups in the order they were first joined
+	groups []*consumerGroup
 }
 
 type TopicCfg struct {
 	Name          string
 	DistType      int
 	SelectionType int
+
+	// When non-nil the topic retains its events in a log
+	// that new subscribers can replay from
+	Log *LogCfg
+
+	// Number of most recent events to hold in memory and hand
+	// to consumers as soon as they subscribe
+	LastValues int
+
+	// Events submitted with an Id that was already seen within
+	// this window are dropped. Zero disables deduplication
+	DedupWindow time.Duration
+
+	// Limit on the rate at which events may be submitted to the
+	// topic by all producers combined
+	RateLimit *RateLimit
+
+	// Operators that thin out the events handed to consumers
+	Shaping *ShapingCfg
 }
 
 func NewTopic(name string) *TopicCfg {
@@ -70,6 +106,94 @@ func (t *TopicCfg) UsingArbitrary() *TopicCfg {
 	return t
 }
 
+// Retain submitted events in a log with no bounds on age or size
+func (t *TopicCfg) UsingLog() *TopicCfg {
+	if t.Log == nil {
+		t.Log = &LogCfg{}
+	}
+	return t
+}
+
+// Retain submitted events in a log, discarding them once they are older
+// than maxAge or once more than maxEvents are retained. Zero means unbounded
+func (t *TopicCfg) UsingRetention(maxAge time.Duration, maxEvents int) *TopicCfg {
+	t.UsingLog()
+	t.Log.MaxAge = maxAge
+	t.Log.MaxEvents = maxEvents
+	return t
+}
+
+// Compact the topic log such that only the latest event for
+// each key (as determined by keyFn) is retained
+func (t *TopicCfg) UsingCompaction(keyFn func(event *Event) string) *TopicCfg {
+	t.UsingLog()
+	t.Log.CompactionKey = keyFn
+	return t
+}
+
+// Keep the latest n events of the topic in memory. Late subscribers
+// immediately receive them, oldest first
+func (t *TopicCfg) UsingLastValueCache(n int) *TopicCfg {
+	t.LastValues = n
+	return t
+}
+
+// Drop events whose Id has already been submitted
+// to the topic within the given window
+func (t *TopicCfg) UsingDeduplication(window time.Duration) *TopicCfg {
+	t.DedupWindow = window
+	return t
+}
+
+// Limit the rate of submissions to the topic
+func (t *TopicCfg) UsingRateLimit(limit RateLimit) *TopicCfg {
+	t.RateLimit = &limit
+	return t
+}
+
+func (t *TopicCfg) shaping() *ShapingCfg {
+	if t.Shaping == nil {
+		t.Shaping = &ShapingCfg{}
+	}
+	return t.Shaping
+}
+
+// Only deliver an event once the topic has been quiet for the given period
+func (t *TopicCfg) UsingDebounce(quiet time.Duration) *TopicCfg {
+	t.shaping().Debounce = quiet
+	return t
+}
+
+// Deliver at most one event per interval for each key produced by keyFn.
+// A nil keyFn throttles the topic as a whole
+func (t *TopicCfg) UsingThrottle(interval time.Duration, keyFn func(event *Event) string) *TopicCfg {
+	t.shaping().Throttle = interval
+	t.shaping().ThrottleKey = keyFn
+	return t
+}
+
+// Deliver each event with the given probability
+func (t *TopicCfg) UsingSampling(rate float64) *TopicCfg {
+	t.shaping().SampleRate = rate
+	return t
+}
+
+// Deliver only every nth event
+func (t *TopicCfg) UsingEveryNth(n int) *TopicCfg {
+	t.shaping().EveryNth = n
+	return t
+}
+
+func (t *eventTopic) cacheLastValue(event *Event) {
+	if t.lastValueCap <= 0 {
+		return
+	}
+	if len(t.lastValues) >= t.lastValueCap {
+		t.lastValues = t.lastValues[len(t.lastValues)-t.lastValueCap+1:]
+	}
+	t.lastValues = append(t.lastValues, event.clone())
+}
+
 func (t *eventTopic) hasSubscriber() bool {
 	for _, s := range t.subscribed {
 		if s != nil {
@@ -79,51 +203,73 @@ func (t *eventTopic) hasSubscriber() bool {
 	return false
 }
 
-func (t *eventTopic) randomSubscriber() (int, error) {
+// Index of the first subscriber that is available
+func (t *eventTopic) firstSubscriber(available func(*subscriber) bool) (int, error) {
+	return firstAvailable(t.subscribed, available)
+}
 
-	var potentials []int
+func (t *eventTopic) randomSubscriber(available func(*subscriber) bool) (int, error) {
+	return randomAvailable(t.subscribed, available)
+}
 
-	for i, s := range t.subscribed {
-		if s != nil {
-			potentials = append(potentials, i)
+func (t *eventTopic) rrNext(available func(*subscriber) bool) (int, error) {
+
+	t.rrMu.Lock()
+	defer t.rrMu.Unlock()
+
+	return nextAvailable(t.subscribed, &t.rrIdx, available)
+}
+
+func firstAvailable(subscribed []*subscriber, available func(*subscriber) bool) (int, error) {
+	for i, s := range subscribed {
+		if s != nil && available(s) {
+			return i, nil
 		}
 	}
+	return -1, ErrTopicNoSubscriberFound
+}
 
-	if len(potentials) == 0 {
-		return -1, ErrTopicNoSubscriberFound
+// Select a random available subscriber. Subscribers are checked in a
+// random order so that only the one selected is asked if it's available
+func randomAvailable(subscribed []*subscriber, available func(*subscriber) bool) (int, error) {
+	for _, i := range rand.Perm(len(subscribed)) {
+		if s := subscribed[i]; s != nil && available(s) {
+			return i, nil
+		}
 	}
-
-	return potentials[rand.IntN(len(potentials))], nil
+	return -1, ErrTopicNoSubscriberFound
 }
 
-func (t *eventTopic) rrNext() (int, error) {
+// Select the next available subscriber in turn, starting from rrIdx
+func nextAvailable(subscribed []*subscriber, rrIdx *int, available func(*subscriber) bool) (int, error) {
 
-	t.rrMu.Lock()
-	defer t.rrMu.Unlock()
+	if len(subscribed) == 0 {
+		return -1, ErrTopicNoSubscriberFound
+	}
 
-	if t.rrIdx >= len(t.subscribed) {
-		t.rrIdx = 0
+	if *rrIdx >= len(subscribed) {
+		*rrIdx = 0
 	}
 
 	checked := 1
 	for {
-		if t.subscribed[t.rrIdx] != nil {
+		if s := subscribed[*rrIdx]; s != nil && available(s) {
 			break
 		}
 
-		t.rrIdx += 1
+		*rrIdx += 1
 
-		if t.rrIdx >= len(t.subscribed) {
-			t.rrIdx = 0
+		if *rrIdx >= len(subscribed) {
+			*rrIdx = 0
 		}
 
 		checked += 1
-		if checked > len(t.subscribed) {
+		if checked > len(subscribed) {
 			return -1, ErrTopicNoSubscriberFound
 		}
 	}
 
-	selected := t.rrIdx
-	t.rrIdx += 1
+	selected := *rrIdx
+	*rrIdx += 1
 	return selected, nil
 }
@@ -3,6 +3,9 @@
 modules=(
   ./
   modules/modhttp
+  modules/modcep
+  modules/modsaga
+  modules/modfsm
 )
 
 go clean -cache